@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// span is a range of text in byte offsets, q0 <= q1.
+type span struct {
+	q0, q1 int64
+}
+
+// addr is a parsed sam/acme address.
+// Simple addresses are chained through next, implicitly joined with "+".
+// Compound addresses "a1,a2" and "a1;a2" have type ',' or ';' with a1 in left and a2 in next.
+type addr struct {
+	typ  byte // one of: # l / ? . $ + - , ;
+	num  int64
+	re   string
+	left *addr
+	next *addr
+}
+
+// parser is used for parsing addresses and sam commands.
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) peek() byte {
+	if p.pos < len(p.s) {
+		return p.s[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) get() byte {
+	c := p.peek()
+	if p.pos < len(p.s) {
+		p.pos++
+	}
+	return c
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) number() int64 {
+	var n int64
+	for c := p.peek(); c >= '0' && c <= '9'; c = p.peek() {
+		n = n*10 + int64(c-'0')
+		p.pos++
+	}
+	return n
+}
+
+// delimited returns the text up to delim or end of line, consuming the delimiter.
+// Backslash followed by delim is replaced by delim.
+func (p *parser) delimited(delim byte) string {
+	var buf []byte
+	for p.pos < len(p.s) && p.s[p.pos] != delim && p.s[p.pos] != '\n' {
+		c := p.s[p.pos]
+		p.pos++
+		if c == '\\' && p.pos < len(p.s) && p.s[p.pos] == delim {
+			c = delim
+			p.pos++
+		} else if c == '\\' && p.pos < len(p.s) {
+			buf = append(buf, c)
+			c = p.s[p.pos]
+			p.pos++
+		}
+		buf = append(buf, c)
+	}
+	if p.peek() == delim {
+		p.pos++
+	}
+	return string(buf)
+}
+
+// compoundAddr parses "a1,a2" and "a1;a2", or a simple address. It returns nil if there is no address.
+func (p *parser) compoundAddr() (*addr, error) {
+	left, err := p.simpleAddr()
+	if err != nil {
+		return nil, err
+	}
+	c := p.peek()
+	if c != ',' && c != ';' {
+		return left, nil
+	}
+	p.pos++
+	next, err := p.compoundAddr()
+	if err != nil {
+		return nil, err
+	}
+	if next != nil && (next.typ == ',' || next.typ == ';') && next.left == nil {
+		return nil, fmt.Errorf("bad address")
+	}
+	return &addr{typ: c, left: left, next: next}, nil
+}
+
+func (p *parser) simpleAddr() (*addr, error) {
+	a := &addr{}
+	switch c := p.peek(); {
+	case c == '#':
+		p.pos++
+		a.typ = '#'
+		a.num = p.number()
+	case c >= '0' && c <= '9':
+		a.typ = 'l'
+		a.num = p.number()
+	case c == '/' || c == '?':
+		p.pos++
+		a.typ = c
+		a.re = p.delimited(c)
+	case c == '.' || c == '$' || c == '+' || c == '-':
+		p.pos++
+		a.typ = c
+	default:
+		return nil, nil
+	}
+
+	next, err := p.simpleAddr()
+	if err != nil {
+		return nil, err
+	}
+	a.next = next
+	if next == nil {
+		return a, nil
+	}
+	switch next.typ {
+	case '.', '$':
+		return nil, fmt.Errorf("bad address")
+	case 'l', '#', '/', '?':
+		if a.typ != '+' && a.typ != '-' {
+			// insert the implicit "+"
+			a.next = &addr{typ: '+', next: next}
+		}
+	}
+	return a, nil
+}
+
+// addrEval evaluates addresses against text.
+type addrEval struct {
+	text []byte
+	dot  span
+	last *lastRegexp
+}
+
+// evalAddr evaluates address s with dot as the current selection.
+// An empty address evaluates to dot.
+func evalAddr(text []byte, dot span, s string) (span, error) {
+	p := &parser{s: s}
+	a, err := p.compoundAddr()
+	if err == nil && p.pos != len(p.s) {
+		err = fmt.Errorf("bad address")
+	}
+	if err != nil {
+		return dot, fmt.Errorf("%s %q at offset %d: %q", err, s, p.pos, s[p.pos:])
+	}
+	if a == nil {
+		return dot, nil
+	}
+	e := &addrEval{text, dot, new(lastRegexp)}
+	return e.address(a, dot, 0)
+}
+
+// address evaluates a, relative to r. Sign is 0 for absolute addresses, 1 for forward, -1 for backward.
+func (e *addrEval) address(a *addr, r span, sign int) (span, error) {
+	size := int64(len(e.text))
+	var err error
+	for ; a != nil; a = a.next {
+		switch a.typ {
+		case 'l':
+			r, err = e.lineAddr(a.num, r, sign)
+		case '#':
+			r, err = e.charAddr(a.num, r, sign)
+		case '.':
+			r = e.dot
+		case '$':
+			r = span{size, size}
+		case '?', '/':
+			if a.typ == '?' {
+				sign = -sign
+				if sign == 0 {
+					sign = -1
+				}
+			}
+			var re *regexp.Regexp
+			re, err = e.last.compile(a.re)
+			if err != nil {
+				return r, err
+			}
+			if sign >= 0 {
+				r, err = e.searchForward(re, r.q1)
+			} else {
+				r, err = e.searchBackward(re, r.q0)
+			}
+		case ',', ';':
+			a1 := span{0, 0}
+			if a.left != nil {
+				a1, err = e.address(a.left, r, 0)
+				if err != nil {
+					return r, err
+				}
+			}
+			if a.typ == ';' {
+				e.dot = a1
+				r = a1
+			}
+			a2 := span{size, size}
+			if a.next != nil {
+				a2, err = e.address(a.next, r, 0)
+				if err != nil {
+					return r, err
+				}
+			}
+			if a2.q0 < a1.q0 {
+				return r, fmt.Errorf("addresses out of order")
+			}
+			return span{a1.q0, a2.q1}, nil
+		case '+', '-':
+			sign = 1
+			if a.typ == '-' {
+				sign = -1
+			}
+			if a.next == nil || a.next.typ == '+' || a.next.typ == '-' {
+				r, err = e.lineAddr(1, r, sign)
+			}
+		}
+		if err != nil {
+			return r, err
+		}
+	}
+	return r, nil
+}
+
+var addressRegexp *regexp.Regexp
+
+func init() {
+	addressRegexp = regexp.MustCompile(`^([0-9]+):([0-9]+)$`)
+}
+
+// lookAddr evaluates the address of "file:addr" for look, from the start of text.
+// Besides sam addresses, "line:column" as printed by compilers is recognized.
+// Trailing colons, as in the output of grep -n, are ignored.
+func lookAddr(text []byte, s string) (span, error) {
+	s = strings.TrimRight(s, ":")
+	l := addressRegexp.FindStringSubmatch(s)
+	if l == nil {
+		return evalAddr(text, span{}, s)
+	}
+	line, _ := strconv.ParseInt(l[1], 10, 64)
+	col, _ := strconv.ParseInt(l[2], 10, 64)
+	e := &addrEval{text: text}
+	r, err := e.lineAddr(line, span{}, 0)
+	if err != nil || col <= 1 {
+		return span{r.q0, r.q0}, err
+	}
+	return e.charAddr(col-1, span{r.q0, r.q0}, 1)
+}
+
+// lastRegexp is the last regular expression used by an address or sam command, for an empty one later in the same Edit or address.
+type lastRegexp string
+
+// compile compiles a sam regular expression, with ^ and $ matching at line boundaries.
+// An empty expression means the last regular expression used.
+func (l *lastRegexp) compile(s string) (*regexp.Regexp, error) {
+	if s == "" {
+		s = string(*l)
+		if s == "" {
+			return nil, fmt.Errorf("no previous regular expression")
+		}
+	}
+	*l = lastRegexp(s)
+	return regexp.Compile("(?m)" + s)
+}
+
+// lineAddr returns line n, including its newline, relative to r. Line 0 is the empty string at the start of the text.
+func (e *addrEval) lineAddr(n int64, r span, sign int) (span, error) {
+	text := e.text
+	size := int64(len(text))
+	var a span
+	if sign >= 0 {
+		var p int64
+		if n == 0 {
+			if sign == 0 || r.q1 == 0 {
+				return span{0, 0}, nil
+			}
+			a.q0 = r.q1
+			p = r.q1 - 1
+		} else {
+			var l int64
+			if sign == 0 || r.q1 == 0 {
+				p = 0
+				l = 1
+			} else {
+				p = r.q1 - 1
+				if text[p] == '\n' {
+					l = 1
+				}
+				p++
+			}
+			for l < n {
+				if p >= size {
+					return r, fmt.Errorf("address out of range")
+				}
+				if text[p] == '\n' {
+					l++
+				}
+				p++
+			}
+			a.q0 = p
+		}
+		for p < size {
+			p++
+			if text[p-1] == '\n' {
+				break
+			}
+		}
+		a.q1 = p
+		return a, nil
+	}
+
+	p := r.q0
+	if n == 0 {
+		a.q1 = r.q0
+	} else {
+		for l := int64(0); l < n; {
+			if p == 0 {
+				l++
+				if l != n {
+					return r, fmt.Errorf("address out of range")
+				}
+			} else {
+				if text[p-1] != '\n' {
+					p--
+				} else {
+					l++
+					if l != n {
+						p--
+					}
+				}
+			}
+		}
+		a.q1 = p
+		if p > 0 {
+			p--
+		}
+	}
+	for p > 0 && text[p-1] != '\n' {
+		p--
+	}
+	a.q0 = p
+	return a, nil
+}
+
+// charAddr returns the empty string at n characters relative to r.
+func (e *addrEval) charAddr(n int64, r span, sign int) (span, error) {
+	text := e.text
+	var p int64
+	switch {
+	case sign == 0:
+		p = 0
+	case sign < 0:
+		p = r.q0
+	default:
+		p = r.q1
+	}
+	for ; n > 0; n-- {
+		if sign < 0 {
+			if p <= 0 {
+				return r, fmt.Errorf("address out of range")
+			}
+			_, size := utf8.DecodeLastRune(text[:p])
+			p -= int64(size)
+		} else {
+			if p >= int64(len(text)) {
+				return r, fmt.Errorf("address out of range")
+			}
+			_, size := utf8.DecodeRune(text[p:])
+			p += int64(size)
+		}
+	}
+	return span{p, p}, nil
+}
+
+// searchForward finds the first match of re at or after offset o, wrapping around.
+func (e *addrEval) searchForward(re *regexp.Regexp, o int64) (span, error) {
+	text := e.text
+	m := re.FindIndex(text[o:])
+	if m != nil && m[0] == 0 && m[1] == 0 && o < int64(len(text)) {
+		// empty match at the starting point, look further
+		_, size := utf8.DecodeRune(text[o:])
+		if mm := re.FindIndex(text[o+int64(size):]); mm != nil {
+			m = []int{mm[0] + size, mm[1] + size}
+		} else {
+			m = nil
+		}
+	}
+	if m != nil {
+		return span{o + int64(m[0]), o + int64(m[1])}, nil
+	}
+	m = re.FindIndex(text)
+	if m == nil {
+		return span{}, fmt.Errorf("no match for regexp")
+	}
+	return span{int64(m[0]), int64(m[1])}, nil
+}
+
+// searchBackward finds the last match of re that starts before offset o, wrapping around.
+func (e *addrEval) searchBackward(re *regexp.Regexp, o int64) (span, error) {
+	l := re.FindAllIndex(e.text, -1)
+	for i := len(l) - 1; i >= 0; i-- {
+		if int64(l[i][0]) < o {
+			return span{int64(l[i][0]), int64(l[i][1])}, nil
+		}
+	}
+	if len(l) > 0 {
+		m := l[len(l)-1]
+		return span{int64(m[0]), int64(m[1])}, nil
+	}
+	return span{}, fmt.Errorf("no match for regexp")
+}
+
+// lineNumber returns the line number for offset o, starting at 1.
+func lineNumber(text []byte, o int64) int {
+	return 1 + bytes.Count(text[:o], []byte{'\n'})
+}
+
+// addrString returns a sam address for s, with line numbers, or with character offsets if chars is set.
+func addrString(text []byte, s span, chars bool) string {
+	if chars {
+		q0 := utf8.RuneCount(text[:s.q0])
+		q1 := q0 + utf8.RuneCount(text[s.q0:s.q1])
+		if q0 == q1 {
+			return fmt.Sprintf("#%d", q0)
+		}
+		return fmt.Sprintf("#%d,#%d", q0, q1)
+	}
+	l0 := lineNumber(text, s.q0)
+	q1 := s.q1
+	if q1 > s.q0 && text[q1-1] == '\n' {
+		q1--
+	}
+	l1 := lineNumber(text, q1)
+	if l0 == l1 {
+		return fmt.Sprintf("%d", l0)
+	}
+	return fmt.Sprintf("%d,%d", l0, l1)
+}
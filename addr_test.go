@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestLookAddr(t *testing.T) {
+	text := []byte("one\ntwo ÿes\nthree\n")
+	tests := []struct {
+		addr string
+		want span
+		err  bool
+	}{
+		{"", span{0, 0}, false},
+		{"1", span{0, 4}, false},
+		{"2", span{4, 13}, false},
+		{"3", span{13, 19}, false},
+		{"4", span{19, 19}, false},
+		{"5", span{}, true},
+		{"0", span{0, 0}, false},
+		{"2:", span{4, 13}, false},
+		{"2::", span{4, 13}, false},
+		{"2:1", span{4, 4}, false},
+		{"2:5", span{8, 8}, false},
+		{"2:6", span{10, 10}, false}, // after the two byte ÿ
+		{"2:6:", span{10, 10}, false},
+		{"2:40", span{}, true},
+		{"9:1", span{}, true},
+		{"#0", span{0, 0}, false},
+		{"#5", span{5, 5}, false},
+		{"#10", span{11, 11}, false},
+		{"#18", span{19, 19}, false},
+		{"#19", span{}, true},
+		{"/two/", span{4, 7}, false},
+		{"/t.*e$/", span{13, 18}, false},
+		{"/^t/;/e/", span{4, 11}, false},
+		{"/^t/,/e/", span{}, true}, // the e of one is before two
+		{"/four/", span{}, true},
+		{"/(/", span{}, true},
+		{"?three?", span{13, 18}, false},
+		{"$", span{19, 19}, false},
+		{"$-1", span{13, 19}, false},
+		{"2,3", span{4, 19}, false},
+		{"3,2", span{}, true},
+		{",", span{0, 19}, false},
+		{"2+#2", span{15, 15}, false},
+		{"x", span{}, true},
+		{"1x", span{}, true},
+		{"1$", span{}, true},
+		{"1,,2", span{}, true},
+		{":", span{0, 0}, false},
+	}
+	for _, tc := range tests {
+		s, err := lookAddr(text, tc.addr)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: err %v, expected error %v", tc.addr, err, tc.err)
+			continue
+		}
+		if err == nil && s != tc.want {
+			t.Errorf("%q: %v, expected %v", tc.addr, s, tc.want)
+		}
+	}
+}
+
+func TestEvalAddrDot(t *testing.T) {
+	text := []byte("a\nb\nc\n")
+	dot := span{2, 4}
+	tests := []struct {
+		addr string
+		want span
+	}{
+		{"", dot},
+		{".", dot},
+		{".+1", span{4, 6}},
+		{".-1", span{0, 2}},
+		{"+", span{4, 6}},
+		{"-", span{0, 2}},
+		{".,$", span{2, 6}},
+		{".;+1", span{2, 6}},
+		{"#1,.", span{1, 4}},
+	}
+	for _, tc := range tests {
+		s, err := evalAddr(text, dot, tc.addr)
+		if err != nil {
+			t.Errorf("%q: %s", tc.addr, err)
+			continue
+		}
+		if s != tc.want {
+			t.Errorf("%q: %v, expected %v", tc.addr, s, tc.want)
+		}
+	}
+}
+
+// Any input returns an address or an error, and does not panic.
+func TestLookAddrNoPanic(t *testing.T) {
+	texts := [][]byte{nil, []byte("x"), []byte("\n"), []byte("ab\ncd\n")}
+	chars := "0123456789#/?.,;$+-:\\^x\n"
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		buf := make([]byte, 1+r.Intn(8))
+		for j := range buf {
+			buf[j] = chars[r.Intn(len(chars))]
+		}
+		text := texts[r.Intn(len(texts))]
+		func() {
+			defer func() {
+				if x := recover(); x != nil {
+					t.Fatalf("%q in %q: panic %v", buf, text, x)
+				}
+			}()
+			s, err := lookAddr(text, string(buf))
+			if err == nil && (s.q0 < 0 || s.q0 > s.q1 || s.q1 > int64(len(text))) {
+				t.Fatalf("%q in %q: bad span %v", buf, text, s)
+			}
+		}()
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"image"
+
+	"9fans.net/go/draw"
+	"github.com/mjl-/duit"
+)
+
+// Changes to the body of a window are copied to its zerox windows, and move the output point of win windows.
+// Changes made by acvi go through fileUI.replace, which knows what it replaced.
+// duit.Edit does not report the changes it makes for typed keys and vi commands.
+// Each such change is the last in its undo history, which insert mode selects with Cmd+y.
+// After a key that may have changed the body, the selection of the last change and the change in size give the replaced text.
+// The vi repeat command can make two changes, those are found by comparing with the text from before.
+// Readers of the event file need the number of characters deleted. Keys in insert mode delete near the cursor, that text is read before the key.
+// Vi commands and redo can delete anywhere, for them the whole text is read before the key.
+
+// bodyHist is the last change in the undo history of the body, as far as acvi knows, and the size of the body.
+type bodyHist struct {
+	q0   int64
+	buf  []byte // text of the last change, at q0
+	size int64
+	ok   bool // whether q0 and buf are known
+}
+
+// keyChange is how a key handled by the body may change it.
+type keyChange struct {
+	modify   bool
+	undo     bool   // undoes the last change
+	before   []byte // text before the key, for keys that can make multiple changes
+	near     span   // text around the cursor before the key, for events
+	nearText []byte
+}
+
+// tracking returns whether changes to the body are needed.
+func (ui *fileUI) tracking() bool {
+	return ui.clones != nil || ui.win != nil || ui.events.listening()
+}
+
+// resetHist is called when the undo history of the body is empty, after creating it or marking it saved.
+func (ui *fileUI) resetHist() {
+	ui.hist = bodyHist{size: editSize(ui.body), ok: true}
+}
+
+// syncHist reads the last change of the body, when changes start to be tracked.
+func (ui *fileUI) syncHist() {
+	s, ok := ui.lastChange()
+	ui.hist = bodyHist{s.q0, []byte(spanText(ui.body, s)), editSize(ui.body), ok}
+}
+
+// lastChange returns the span of the last change in the undo history of the body, an empty span at 0 without history.
+// It returns false if the body cannot handle keys, e.g. before its first layout.
+func (ui *fileUI) lastChange() (span, bool) {
+	kid := ui.bodyBox.Kids[0]
+	r := dui.ScaleSpace(duit.EditPadding).Inset(image.Rect(dui.Scale(duit.ScrollbarSize), 0, kid.R.Dx(), kid.R.Dy()))
+	if r.Empty() || ui.stale() {
+		return span{}, false
+	}
+	m := draw.Mouse{Point: r.Min}
+	key := func(k rune) {
+		ui.body.Key(dui, kid, k, m, image.ZP)
+	}
+
+	c := ui.body.Cursor()
+	mode := ui.vi.mode
+	if mode != viInsert {
+		key('i')
+	}
+	ui.body.SetCursor(duit.Cursor{})
+	key(draw.KeyCmd + 'y')
+	c0, c1 := ui.body.Cursor().Ordered()
+	if mode != viInsert {
+		// escape returns to visual mode if text is selected, and to command mode if not
+		if mode == viCommand {
+			ui.body.SetCursor(duit.Cursor{Cur: c.Cur, Start: c.Cur})
+		} else {
+			ui.body.SetCursor(c)
+		}
+		key(draw.KeyEscape)
+	}
+	ui.body.SetCursor(c)
+	return span{c0, c1}, true
+}
+
+// keyChange is called by the body before it handles key k, typed in mode, with how k may change the body.
+func (ui *fileUI) keyChange(mode viMode, k rune, modify, undo, repeat bool) {
+	ui.keyed = keyChange{modify: modify, undo: undo}
+	if !modify || !ui.tracking() {
+		return
+	}
+	listening := ui.events.listening()
+	if repeat || listening && !undo && (mode != viInsert || k == draw.KeyCmd+'Z') {
+		ui.keyed.before, _ = ui.body.Text()
+	} else if listening {
+		// from well before the cursor to the end of its line, e.g. control-w and control-k
+		c0, c1 := ui.body.Cursor().Ordered()
+		r := ui.body.EditReader(c1)
+		r.Line(true)
+		s := span{maximum64(0, c0-4096), r.Offset()}
+		ui.keyed.near, ui.keyed.nearText = s, []byte(spanText(ui.body, s))
+	}
+}
+
+// bodyEvent finds the change the body made while handling a key, and passes it on.
+func (ui *fileUI) bodyEvent(kc keyChange) {
+	if !ui.tracking() {
+		return
+	}
+	h := ui.hist
+	size := editSize(ui.body)
+	if !kc.modify && size == h.size {
+		return
+	}
+	d := size - h.size
+	s, ok := ui.lastChange()
+	if !ok {
+		return
+	}
+	// duit can leave the cursor after the end of the text, changes made there end up elsewhere
+	valid := s.q1 <= size
+	buf := []byte(spanText(ui.body, s))
+	ui.hist = bodyHist{s.q0, buf, size, valid}
+
+	// o in the text before the key was replaced by nbuf, del is the text of o if known
+	var o span
+	var del, nbuf []byte
+	switch {
+	case kc.before != nil || !h.ok || !valid:
+		o, del, nbuf, ok = ui.diffChange(kc.before, h.size)
+	case kc.undo && int64(len(h.buf))+d >= 0:
+		// the undone change is replaced by the text it had replaced
+		o = span{h.q0, h.q0 + int64(len(h.buf))}
+		del = h.buf
+		nbuf = []byte(spanText(ui.body, span{h.q0, o.q1 + d}))
+	case kc.undo:
+		o, del, nbuf, ok = ui.diffChange(nil, h.size)
+	case s.q0 == h.q0 && d == 0 && bytes.Equal(buf, h.buf):
+		return
+	default:
+		o = span{s.q0, s.q1 - d}
+		nbuf = buf
+		ok = o.q1 >= o.q0
+		if !ok {
+			o, del, nbuf, ok = ui.diffChange(nil, h.size)
+		} else if n := kc.near; kc.nearText != nil && o.q0 >= n.q0 && o.q1 <= n.q1 {
+			del = kc.nearText[o.q0-n.q0 : o.q1-n.q0]
+		}
+	}
+	if ok && (o.q1 > o.q0 || len(nbuf) > 0) {
+		ui.bodyChanged(o, len(nbuf))
+		ui.zeroxCopy(o, nbuf)
+		ui.keyEvents(o, del, nbuf)
+	}
+}
+
+// diffChange returns the change from before, or from the text of a zerox window, to the body.
+// Without either, the whole body of osize bytes has changed, and the deleted text is not known.
+func (ui *fileUI) diffChange(before []byte, osize int64) (o span, del, ins []byte, ok bool) {
+	text, err := ui.body.Text()
+	if topUI.error(ui.path(), err, "read body") {
+		return
+	}
+	if before == nil {
+		for _, f := range ui.zeroxFiles() {
+			if f != ui {
+				before, err = f.body.Text()
+				if topUI.error(f.path(), err, "read body") {
+					return
+				}
+				break
+			}
+		}
+	}
+	if before == nil {
+		return span{0, osize}, nil, text, true
+	}
+	n, del, ins := textDiff(before, text)
+	return span{int64(n), int64(n + len(del))}, del, ins, true
+}
+
+// bodyKeyed passes on the changes the body made for a key, and sends lines typed in win windows.
+func (ui *fileUI) bodyKeyed() {
+	ui.bodyEvent(ui.keyed)
+	if ui.win != nil {
+		ui.win.sendLines(ui)
+	}
+}
+
+// bodyChanged is called after s in the body was replaced with n bytes.
+func (ui *fileUI) bodyChanged(s span, n int) {
+	ui.edits++
+	if ui.win != nil {
+		ui.win.replaced(s, n)
+	}
+}
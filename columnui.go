@@ -14,6 +14,7 @@ type columnUI struct {
 	header    *duit.Edit
 	headerBox *duit.Box
 	files     *filesUI
+	tagFit    tagFit
 	duit.Box
 }
 
@@ -70,7 +71,7 @@ func newColumnUI(paths []string) *columnUI {
 	}
 	ui.headerBox = &duit.Box{
 		Width:  -1,
-		Height: tagHeight(),
+		Height: -1,
 		Kids:   duit.NewKids(headerSplit),
 	}
 	var files []*fileUI
@@ -78,10 +79,7 @@ func newColumnUI(paths []string) *columnUI {
 		files = append(files, newFileUI(ui, p))
 	}
 	ui.files = newFilesUI(ui, files)
-	ui.Box.Margin = image.Pt(0, 1)
 	ui.Box.Background = textColors.Fg
-	ui.Box.Width = -1
-	ui.Box.Height = -1
 	ui.Box.Kids = duit.NewKids(ui.headerBox, ui.files)
 	return ui
 }
@@ -91,6 +89,12 @@ func (ui *columnUI) execute(filename, t string, edit *duit.Edit) {
 	case "New":
 		ui.addFile("")
 	case "Delcol":
+		if len(topUI.columns) == 1 || !topUI.clean(filename, ui.files.files) {
+			return
+		}
+		for _, f := range append([]*fileUI{}, ui.files.files...) {
+			f.del()
+		}
 		topUI.removeColumn(ui)
 		dui.MarkLayout(topUI)
 	default:
@@ -100,7 +104,9 @@ func (ui *columnUI) execute(filename, t string, edit *duit.Edit) {
 
 func (ui *columnUI) look(filename, t string) {
 	log.Printf("columnUI.look %q\n", t)
-	topUI.look(filename, t, true)
+	topUI.plumb(filename, t, func() {
+		topUI.look(filename, t, true)
+	})
 }
 
 func (ui *columnUI) addFile(filename string) *fileUI {
@@ -168,6 +174,17 @@ func (ui *columnUI) growIndex(index int) {
 	dui.MarkLayout(ui.files)
 }
 
+func (ui *columnUI) Layout(dui *duit.DUI, self *duit.Kid, sizeAvail image.Point, force bool) {
+	if duit.KidsLayout(dui, self, ui.Kids, force) {
+		return
+	}
+	ui.tagFit = layoutTag(dui, self, ui.Kids, ui.header, sizeAvail, dui.Scale(1))
+}
+
+func (ui *columnUI) Draw(dui *duit.DUI, self *duit.Kid, img *draw.Image, orig image.Point, m draw.Mouse, force bool) {
+	duit.KidsDraw(dui, self, ui.Kids, self.R.Size(), ui.Box.Background, img, orig, m, force)
+}
+
 func (ui *columnUI) Key(dui *duit.DUI, self *duit.Kid, k rune, m draw.Mouse, orig image.Point) (r duit.Result) {
 	switch k {
 	case draw.KeyCmd + 'I':
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/mjl-/duit"
+)
+
+var errStale = errors.New("file was changed in place while open, Get to reload")
+
+// diskFile is the file backing the body of a window. The edit reads text it has not modified lazily from the file.
+// If the file is changed in place, e.g. truncated or rewritten, those reads no longer return the original text.
+// Each read checks whether the file changed. Once it did, the file is stale and reads fail, so the body never shows a mix of old and new text.
+// The window does not accept changes to its body until Get reloads it.
+// Zerox windows share the file, it is closed when the last of them is done with it.
+type diskFile struct {
+	*os.File
+	size  int64
+	mtime time.Time
+	stale bool
+	refs  int
+}
+
+func openDiskFile(name string) (*diskFile, os.FileInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return &diskFile{File: f, size: fi.Size(), mtime: fi.ModTime(), refs: 1}, fi, nil
+}
+
+func (f *diskFile) release() {
+	f.refs--
+	if f.refs == 0 {
+		f.Close()
+	}
+}
+
+func (f *diskFile) ReadAt(buf []byte, off int64) (int, error) {
+	if f.check() {
+		return 0, errStale
+	}
+	return f.File.ReadAt(buf, off)
+}
+
+// check returns whether the file was changed since it was opened.
+func (f *diskFile) check() bool {
+	if !f.stale {
+		fi, err := f.File.Stat()
+		f.stale = err != nil || fi.Size() != f.size || !fi.ModTime().Equal(f.mtime)
+	}
+	return f.stale
+}
+
+// bodySource is what the edit of a body reads its unmodified text from: a diskFile, or text in memory.
+// After a save, it is pointed at the new file before the edit is marked saved, which makes the edit read from it.
+// So the edit, with its cursor and scroll position, stays.
+type bodySource struct {
+	duit.SeekReaderAt
+}
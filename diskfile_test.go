@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDiskFileStale(t *testing.T) {
+	f, err := ioutil.TempFile("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("old text\n")
+	f.Close()
+
+	df, _, err := openDiskFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer df.Close()
+	buf := make([]byte, 3)
+	if n, err := df.ReadAt(buf, 0); err != nil || string(buf[:n]) != "old" {
+		t.Fatalf("read %q, %v, expected old", buf[:n], err)
+	}
+
+	// rewritten in place, with a different size and mtime
+	if err := ioutil.WriteFile(f.Name(), []byte("new\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(f.Name(), future, future)
+	if n, err := df.ReadAt(buf, 0); err != errStale {
+		t.Fatalf("read after change got %q, %v, expected errStale", buf[:n], err)
+	}
+	if !df.check() {
+		t.Fatalf("file not stale")
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mjl-/duit"
+)
+
+// Dump and Load save and restore the columns and windows in a plain text file, for example:
+//
+//	acvi dump
+//	wd /home/user/src
+//	column 640 "New Delcol "
+//	window 420 10 10 clean "/home/user/src/main.go Del | "
+//	window 200 0 5 dirty "/home/user/src/+Errors Del | "
+//	body 5
+//	hello
+//
+// Column widths and window heights are in pixels, they are restored in the same proportions.
+// Windows have the start and end of the cursor in bytes, whether they are dirty, and their tag. Loading scrolls to the cursor.
+// Windows that are dirty or not backed by a file are followed by their contents in a body line with the size in bytes, the contents and a newline.
+
+type dumpColumn struct {
+	width   int
+	tag     string
+	windows []dumpWindow
+}
+
+type dumpWindow struct {
+	height int
+	cursor duit.Cursor
+	dirty  bool
+	tag    string
+	body   []byte // nil if the contents are read from the file
+}
+
+func defaultDumpFile() string {
+	return os.Getenv("HOME") + "/acvi.dump"
+}
+
+// dumpBody returns whether the contents of the window must be stored in a dump.
+func (ui *fileUI) dumpBody() bool {
+	p := ui.path()
+	if p == "" || ui.square.dirty || scratch(p) {
+		return true
+	}
+	if strings.HasSuffix(p, "/") {
+		return false
+	}
+	_, err := os.Stat(p)
+	return os.IsNotExist(err)
+}
+
+func (ui *mainUI) dump(filename string) error {
+	var b bytes.Buffer
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&b, "acvi dump\nwd %s\n", wd)
+	dims := ui.Split.Dimensions(dui, nil)
+	for i, col := range ui.columns {
+		tag, err := col.header.Text()
+		if err != nil {
+			return err
+		}
+		width := 0
+		if i < len(dims) {
+			width = dims[i]
+		}
+		fmt.Fprintf(&b, "column %d %s\n", width, strconv.Quote(string(tag)))
+		for j, f := range col.files.files {
+			tag, err := f.header.Text()
+			if err != nil {
+				return err
+			}
+			height := 0
+			if j < len(col.files.heights) {
+				height = col.files.heights[j]
+			}
+			c := f.body.Cursor()
+			state := "clean"
+			if f.square.dirty {
+				state = "dirty"
+			}
+			fmt.Fprintf(&b, "window %d %d %d %s %s\n", height, c.Start, c.Cur, state, strconv.Quote(string(tag)))
+			if f.dumpBody() {
+				buf, err := f.body.Text()
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(&b, "body %d\n", len(buf))
+				b.Write(buf)
+				b.WriteString("\n")
+			}
+		}
+	}
+	return ioutil.WriteFile(filename, b.Bytes(), 0666)
+}
+
+func parseDump(r *bufio.Reader) (wd string, columns []*dumpColumn, err error) {
+	line := func() (string, error) {
+		s, err := r.ReadString('\n')
+		if err == io.EOF && s != "" {
+			err = nil
+		}
+		return strings.TrimSuffix(s, "\n"), err
+	}
+
+	s, err := line()
+	if err != nil || s != "acvi dump" {
+		return "", nil, fmt.Errorf("not an acvi dump")
+	}
+	for {
+		s, err := line()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		t := strings.SplitN(s, " ", 2)
+		bad := func() error {
+			return fmt.Errorf("bad line %q", s)
+		}
+		switch t[0] {
+		case "wd":
+			if len(t) != 2 {
+				return "", nil, bad()
+			}
+			wd = t[1]
+		case "column":
+			t = strings.SplitN(s, " ", 3)
+			if len(t) != 3 {
+				return "", nil, bad()
+			}
+			c := &dumpColumn{}
+			c.width, err = strconv.Atoi(t[1])
+			if err == nil {
+				c.tag, err = strconv.Unquote(t[2])
+			}
+			if err != nil {
+				return "", nil, bad()
+			}
+			columns = append(columns, c)
+		case "window":
+			t = strings.SplitN(s, " ", 6)
+			if len(t) != 6 || len(columns) == 0 || (t[4] != "clean" && t[4] != "dirty") {
+				return "", nil, bad()
+			}
+			w := dumpWindow{dirty: t[4] == "dirty"}
+			w.height, err = strconv.Atoi(t[1])
+			if err == nil {
+				w.cursor.Start, err = strconv.ParseInt(t[2], 10, 64)
+			}
+			if err == nil {
+				w.cursor.Cur, err = strconv.ParseInt(t[3], 10, 64)
+			}
+			if err == nil {
+				w.tag, err = strconv.Unquote(t[5])
+			}
+			if err != nil {
+				return "", nil, bad()
+			}
+			c := columns[len(columns)-1]
+			c.windows = append(c.windows, w)
+		case "body":
+			if len(t) != 2 || len(columns) == 0 || len(columns[len(columns)-1].windows) == 0 {
+				return "", nil, bad()
+			}
+			c := columns[len(columns)-1]
+			n, err := strconv.Atoi(t[1])
+			if err != nil || n < 0 {
+				return "", nil, bad()
+			}
+			buf := make([]byte, n+1)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return "", nil, fmt.Errorf("reading body: %s", err)
+			}
+			if buf[n] != '\n' {
+				return "", nil, fmt.Errorf("missing newline after body")
+			}
+			c.windows[len(c.windows)-1].body = buf[:n]
+		default:
+			return "", nil, bad()
+		}
+	}
+	if len(columns) == 0 {
+		return "", nil, fmt.Errorf("no columns in dump")
+	}
+	return
+}
+
+// load replaces all columns and windows with those from the dump in filename.
+// The new columns and windows are in place before files are read, so errors show up in the new layout.
+func (ui *mainUI) load(filename string) error {
+	for _, w := range ui.windows() {
+		if w.square.dirty {
+			return fmt.Errorf("%s is dirty", w.path())
+		}
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	wd, dcols, err := parseDump(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
+	if wd != "" {
+		if err := os.Chdir(wd); err != nil {
+			return err
+		}
+	}
+
+	var cols []*columnUI
+	var widths []int
+	var files []*fileUI
+	var windows []dumpWindow
+	for _, dc := range dcols {
+		col := newColumnUI(nil)
+		setTag(col.header, dc.tag)
+		var colFiles []*fileUI
+		var heights []int
+		for _, dw := range dc.windows {
+			f := newFileUI(col, "")
+			setTag(f.header, dw.tag)
+			colFiles = append(colFiles, f)
+			heights = append(heights, dw.height)
+		}
+		files = append(files, colFiles...)
+		windows = append(windows, dc.windows...)
+		col.files = newFilesUI(col, colFiles)
+		if len(colFiles) > 0 && positive(heights) {
+			col.files.heights = heights
+		}
+		col.Kids[1].UI = col.files
+		cols = append(cols, col)
+		widths = append(widths, dc.width)
+	}
+	for _, w := range ui.windows() {
+		w.del()
+	}
+	ui.columns = cols
+	uis := make([]duit.UI, len(cols))
+	for i, col := range cols {
+		uis[i] = col
+	}
+	ui.Kids = duit.NewKids(uis...)
+	if positive(widths) {
+		ui.Split.Dimensions(dui, widths)
+	}
+	dui.MarkLayout(nil)
+
+	for i, f := range files {
+		dw := windows[i]
+		if dw.body != nil && !dw.dirty {
+			f.initText(dw.body)
+		} else {
+			f.init(f.path())
+		}
+		if dw.body != nil && dw.dirty {
+			text, err := f.body.Text()
+			if !ui.error(f.path(), err, "load") {
+				o, del, ins := textDiff(text, dw.body)
+				f.replace('F', f.body, span{int64(o), int64(o + len(del))}, ins)
+			}
+		}
+		size := editSize(f.body)
+		c := dw.cursor
+		if c.Start > size || c.Cur > size || c.Start < 0 || c.Cur < 0 {
+			c = duit.Cursor{}
+		}
+		f.body.SetCursor(c)
+		f.body.ScrollCursor(dui)
+	}
+	return nil
+}
+
+func positive(l []int) bool {
+	for _, v := range l {
+		if v <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// setTag replaces the text of a tag and puts the cursor at the end.
+func setTag(edit *duit.Edit, tag string) {
+	edit.Replace(duit.Cursor{Cur: editSize(edit)}, []byte(tag))
+	n := int64(len(tag))
+	edit.SetCursor(duit.Cursor{Cur: n, Start: n})
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 9P2000 message types, only those we need to serve a file tree and to talk to the plumber.
+const (
+	tversion = 100 + iota
+	rversion
+	tauth
+	rauth
+	tattach
+	rattach
+	terror // illegal
+	rerror
+	tflush
+	rflush
+	twalk
+	rwalk
+	topen
+	ropen
+	tcreate
+	rcreate
+	tread
+	rread
+	twrite
+	rwrite
+	tclunk
+	rclunk
+	tremove
+	rremove
+	tstat
+	rstat
+	twstat
+	rwstat
+)
+
+const (
+	qtdir  = 0x80
+	dmdir  = 0x80000000
+	notag  = 0xffff
+	nofid  = 0xffffffff
+	iohdr  = 24 // size[4] type[1] tag[2] fid[4] offset[8] count[4], header of read/write messages
+	maxMsg = 8*1024 + iohdr
+)
+
+const (
+	oread  = 0
+	owrite = 1
+	ordwr  = 2
+	oexec  = 3
+	otrunc = 0x10
+)
+
+type qid struct {
+	Type uint8
+	Vers uint32
+	Path uint64
+}
+
+// fcall is a 9P2000 message. Only the fields for Type are used.
+type fcall struct {
+	Type    uint8
+	Tag     uint16
+	Fid     uint32
+	Newfid  uint32
+	Afid    uint32
+	Msize   uint32
+	Version string
+	Uname   string
+	Aname   string
+	Ename   string
+	Oldtag  uint16
+	Wname   []string
+	Wqid    []qid
+	Qid     qid
+	Iounit  uint32
+	Mode    uint8
+	Name    string
+	Perm    uint32
+	Offset  uint64
+	Count   uint32
+	Data    []byte
+	Stat    []byte
+}
+
+type fcallReader struct {
+	buf []byte
+	err error
+}
+
+func (r *fcallReader) take(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.buf) < n {
+		r.err = fmt.Errorf("short message")
+		return nil
+	}
+	buf := r.buf[:n]
+	r.buf = r.buf[n:]
+	return buf
+}
+
+func (r *fcallReader) u8() uint8 {
+	if b := r.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *fcallReader) u16() uint16 {
+	if b := r.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *fcallReader) u32() uint32 {
+	if b := r.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *fcallReader) u64() uint64 {
+	if b := r.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *fcallReader) qid() qid {
+	return qid{r.u8(), r.u32(), r.u64()}
+}
+
+func (r *fcallReader) str() string {
+	n := r.u16()
+	return string(r.take(int(n)))
+}
+
+// readFcall reads a single T-message from r.
+func readFcall(r io.Reader) (*fcall, error) {
+	var sizebuf [4]byte
+	if _, err := io.ReadFull(r, sizebuf[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(sizebuf[:])
+	if size < 7 || size > maxMsg {
+		return nil, fmt.Errorf("bad message size %d", size)
+	}
+	buf := make([]byte, size-4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+
+	mr := &fcallReader{buf: buf}
+	f := &fcall{}
+	f.Type = mr.u8()
+	f.Tag = mr.u16()
+	switch f.Type {
+	case tversion:
+		f.Msize = mr.u32()
+		f.Version = mr.str()
+	case tauth:
+		f.Afid = mr.u32()
+		f.Uname = mr.str()
+		f.Aname = mr.str()
+	case tattach:
+		f.Fid = mr.u32()
+		f.Afid = mr.u32()
+		f.Uname = mr.str()
+		f.Aname = mr.str()
+	case tflush:
+		f.Oldtag = mr.u16()
+	case twalk:
+		f.Fid = mr.u32()
+		f.Newfid = mr.u32()
+		n := mr.u16()
+		for i := 0; i < int(n); i++ {
+			f.Wname = append(f.Wname, mr.str())
+		}
+	case topen:
+		f.Fid = mr.u32()
+		f.Mode = mr.u8()
+	case tcreate:
+		f.Fid = mr.u32()
+		f.Name = mr.str()
+		f.Perm = mr.u32()
+		f.Mode = mr.u8()
+	case tread:
+		f.Fid = mr.u32()
+		f.Offset = mr.u64()
+		f.Count = mr.u32()
+	case twrite:
+		f.Fid = mr.u32()
+		f.Offset = mr.u64()
+		n := mr.u32()
+		f.Data = mr.take(int(n))
+	case tclunk, tremove, tstat:
+		f.Fid = mr.u32()
+	case twstat:
+		f.Fid = mr.u32()
+		n := mr.u16()
+		f.Stat = mr.take(int(n))
+	case rversion:
+		f.Msize = mr.u32()
+		f.Version = mr.str()
+	case rerror:
+		f.Ename = mr.str()
+	case rattach:
+		f.Qid = mr.qid()
+	case rwalk:
+		n := mr.u16()
+		for i := 0; i < int(n); i++ {
+			f.Wqid = append(f.Wqid, mr.qid())
+		}
+	case ropen, rcreate:
+		f.Qid = mr.qid()
+		f.Iounit = mr.u32()
+	case rread:
+		n := mr.u32()
+		f.Data = mr.take(int(n))
+	case rwrite:
+		f.Count = mr.u32()
+	case rstat:
+		n := mr.u16()
+		f.Stat = mr.take(int(n))
+	case rflush, rclunk, rremove, rwstat:
+	default:
+		return nil, fmt.Errorf("bad message type %d", f.Type)
+	}
+	if mr.err != nil {
+		return nil, mr.err
+	}
+	return f, nil
+}
+
+type fcallWriter struct {
+	buf []byte
+}
+
+func (w *fcallWriter) u8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *fcallWriter) u16(v uint16) {
+	w.buf = append(w.buf, byte(v), byte(v>>8))
+}
+
+func (w *fcallWriter) u32(v uint32) {
+	w.buf = append(w.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (w *fcallWriter) u64(v uint64) {
+	w.u32(uint32(v))
+	w.u32(uint32(v >> 32))
+}
+
+func (w *fcallWriter) str(s string) {
+	w.u16(uint16(len(s)))
+	w.buf = append(w.buf, s...)
+}
+
+func (w *fcallWriter) qid(q qid) {
+	w.u8(q.Type)
+	w.u32(q.Vers)
+	w.u64(q.Path)
+}
+
+// bytes returns the wire representation of f.
+func (f *fcall) bytes() []byte {
+	w := &fcallWriter{buf: make([]byte, 4, 64)}
+	w.u8(f.Type)
+	w.u16(f.Tag)
+	switch f.Type {
+	case rversion:
+		w.u32(f.Msize)
+		w.str(f.Version)
+	case rerror:
+		w.str(f.Ename)
+	case rattach:
+		w.qid(f.Qid)
+	case rwalk:
+		w.u16(uint16(len(f.Wqid)))
+		for _, q := range f.Wqid {
+			w.qid(q)
+		}
+	case ropen, rcreate:
+		w.qid(f.Qid)
+		w.u32(f.Iounit)
+	case rread:
+		w.u32(uint32(len(f.Data)))
+		w.buf = append(w.buf, f.Data...)
+	case rwrite:
+		w.u32(f.Count)
+	case rstat:
+		w.u16(uint16(len(f.Stat)))
+		w.buf = append(w.buf, f.Stat...)
+	case rflush, rclunk, rremove, rwstat:
+	case tversion:
+		w.u32(f.Msize)
+		w.str(f.Version)
+	case tattach:
+		w.u32(f.Fid)
+		w.u32(f.Afid)
+		w.str(f.Uname)
+		w.str(f.Aname)
+	case twalk:
+		w.u32(f.Fid)
+		w.u32(f.Newfid)
+		w.u16(uint16(len(f.Wname)))
+		for _, s := range f.Wname {
+			w.str(s)
+		}
+	case topen:
+		w.u32(f.Fid)
+		w.u8(f.Mode)
+	case tread:
+		w.u32(f.Fid)
+		w.u64(f.Offset)
+		w.u32(f.Count)
+	case twrite:
+		w.u32(f.Fid)
+		w.u64(f.Offset)
+		w.u32(uint32(len(f.Data)))
+		w.buf = append(w.buf, f.Data...)
+	case tclunk:
+		w.u32(f.Fid)
+	default:
+		panic(fmt.Sprintf("bytes for bad message type %d", f.Type))
+	}
+	binary.LittleEndian.PutUint32(w.buf, uint32(len(w.buf)))
+	return w.buf
+}
+
+// statBytes returns a 9P stat entry for a file owned by user.
+func statBytes(name string, q qid, mode uint32, length uint64, user string) []byte {
+	w := &fcallWriter{buf: make([]byte, 2, 64)}
+	w.u16(0) // type
+	w.u32(0) // dev
+	w.qid(q)
+	w.u32(mode)
+	w.u32(0) // atime
+	w.u32(0) // mtime
+	w.u64(length)
+	w.str(name)
+	w.str(user)
+	w.str(user)
+	w.str(user)
+	binary.LittleEndian.PutUint16(w.buf, uint16(len(w.buf)-2))
+	return w.buf
+}
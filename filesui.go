@@ -3,6 +3,7 @@ package main
 import (
 	"image"
 
+	"9fans.net/go/draw"
 	"github.com/mjl-/duit"
 )
 
@@ -23,9 +24,7 @@ func newFilesUI(column *columnUI, files []*fileUI) *filesUI {
 		uis[i] = f
 	}
 
-	ui.Box.Margin = image.Pt(0, 2) // todo: fix the off-by one, or rather, don't use box to layout, doesn't make enough sense
 	ui.Box.Background = textColors.Fg
-	ui.Box.Width = -1
 	if len(uis) == 0 {
 		ui.Box.Kids = duit.NewKids(&white{})
 	} else {
@@ -34,8 +33,9 @@ func newFilesUI(column *columnUI, files []*fileUI) *filesUI {
 	return ui
 }
 
+// marginY is the space between windows.
 func (ui *filesUI) marginY() int {
-	return dui.Scale(ui.Box.Margin.Y)
+	return dui.Scale(2)
 }
 
 // total heights excluding padding
@@ -67,6 +67,66 @@ func (ui *filesUI) add(file *fileUI) {
 	dui.Focus(file)
 }
 
+// insert adds file at y, taking the space below y from the window at y.
+func (ui *filesUI) insert(file *fileUI, y int) {
+	if len(ui.files) == 0 {
+		ui.add(file)
+		return
+	}
+	marginY := ui.marginY()
+	i := len(ui.files) - 1
+	top := 0
+	for j, h := range ui.heights {
+		if y < top+h+marginY || j == len(ui.heights)-1 {
+			i = j
+			break
+		}
+		top += h + marginY
+	}
+	h := ui.heights[i]
+	min := tagHeight()
+	keep := y - top
+	if keep < min {
+		keep = min
+	}
+	if keep > h-marginY-min {
+		keep = (h - marginY) / 2
+	}
+	ui.files = append(append(append([]*fileUI{}, ui.files[:i+1]...), file), ui.files[i+1:]...)
+	ui.Kids = append(append(append([]*duit.Kid{}, ui.Kids[:i+1]...), &duit.Kid{UI: file}), ui.Kids[i+1:]...)
+	ui.heights = append(append(append([]int{}, ui.heights[:i]...), keep, h-marginY-keep), ui.heights[i+1:]...)
+	dui.MarkLayout(nil)
+	dui.Focus(file)
+}
+
+// move moves file, a window in this column, to y.
+// Removing file gives its space to a neighbor, the other windows keep their position, so y stays valid.
+// But the neighbor is the window below, and dropping file on itself would put it below that window.
+// Instead, the window above file gets the space up to y.
+func (ui *filesUI) move(file *fileUI, y int) {
+	marginY := ui.marginY()
+	top := 0
+	for i, f := range ui.files {
+		h := ui.heights[i]
+		if f != file {
+			top += h + marginY
+			continue
+		}
+		if y < top || y >= top+h+marginY {
+			break
+		}
+		if d := minimum(y-top, h-tagHeight()); i > 0 && d > 0 {
+			ui.heights[i-1] += d
+			ui.heights[i] -= d
+			dui.MarkLayout(nil)
+		}
+		dui.Focus(file)
+		return
+	}
+	ui.remove(file)
+	ui.insert(file, y)
+}
+
 func (ui *filesUI) remove(file *fileUI) {
 	for i := range ui.files {
 		if ui.files[i] != file {
@@ -88,68 +148,66 @@ func (ui *filesUI) remove(file *fileUI) {
 			ui.Box.Kids = duit.NewKids(&white{})
 		}
 		dui.MarkLayout(nil)
-		dui.Focus(file)
 		return
 	}
 	panic("no file removed?")
 }
 
+// Layout gives each window its height in heights, which add up to the available height minus the margins.
+// Heights are assigned evenly at first, and scaled proportionally when the available height changes.
 func (ui *filesUI) Layout(dui *duit.DUI, self *duit.Kid, sizeAvail image.Point, force bool) {
 	if duit.KidsLayout(dui, self, ui.Kids, force) {
 		return
 	}
-
-	if len(ui.Kids) == 0 {
+	self.R = image.Rect(0, 0, sizeAvail.X, sizeAvail.Y)
+	if len(ui.files) == 0 {
+		k := ui.Kids[0]
+		k.UI.Layout(dui, k, sizeAvail, true)
+		k.R = self.R
 		return
 	}
 
-	if len(ui.heights) != len(ui.Kids) {
-		// initial assignment
+	marginY := ui.marginY()
+	avail := sizeAvail.Y - marginY*(len(ui.Kids)-1)
+	if avail < 0 {
+		avail = 0
+	}
+	total := 0
+	for i, h := range ui.heights {
+		if h < 0 {
+			ui.heights[i] = 0
+		}
+		total += ui.heights[i]
+	}
+	switch {
+	case len(ui.heights) != len(ui.Kids) || total == 0:
 		ui.heights = make([]int, len(ui.Kids))
-		single := (sizeAvail.Y - ui.marginY()*(len(ui.Kids)-1)) / len(ui.Kids)
-		marginY := ui.marginY()
-		for i := range ui.Kids {
-			if i == len(ui.Kids)-1 {
-				ui.heights[i] = sizeAvail.Y - i*(single+marginY)
-			} else {
-				ui.heights[i] = single
-			}
+		for i := range ui.heights {
+			ui.heights[i] = avail / len(ui.Kids)
 		}
-	} else {
-		total := ui.height()
-		if total != sizeAvail.Y {
-			if total == 0 {
-				if len(ui.heights) != 1 {
-					panic("total height 0 with multiple files?")
-				}
-				ui.heights[0] = sizeAvail.Y
+		ui.heights[len(ui.heights)-1] += avail % len(ui.Kids)
+	case total != avail:
+		left := avail
+		for i, h := range ui.heights {
+			if i == len(ui.heights)-1 {
+				ui.heights[i] = left
 			} else {
-				// probably new layout height, assign anew in same ratio
-				left := sizeAvail.Y - ui.marginY()*(len(ui.Kids)-1)
-				for i, h := range ui.heights {
-					if i == len(ui.heights)-1 {
-						ui.heights[i] = left
-					} else {
-						ui.heights[i] = h * sizeAvail.Y / total
-					}
-					left -= ui.heights[i]
-				}
-				if left != 0 {
-					panic("left != 0")
-				}
+				ui.heights[i] = h * avail / total
 			}
+			left -= ui.heights[i]
 		}
 	}
 
 	y := 0
-	marginY := ui.marginY()
 	for i, k := range ui.Kids {
 		k.UI.Layout(dui, k, image.Pt(sizeAvail.X, ui.heights[i]), true)
-		k.R = k.R.Add(image.Pt(0, y))
+		k.R = image.Rect(0, y, sizeAvail.X, y+ui.heights[i])
 		y += ui.heights[i] + marginY
 	}
-	y--
-	self.R = image.Rect(0, 0, sizeAvail.X, y)
+}
+
+func (ui *filesUI) Draw(dui *duit.DUI, self *duit.Kid, img *draw.Image, orig image.Point, m draw.Mouse, force bool) {
+	duit.KidsDraw(dui, self, ui.Kids, self.R.Size(), ui.Box.Background, img, orig, m, force)
 }
 
 func (ui *filesUI) Print(self *duit.Kid, indent int) {
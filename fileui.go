@@ -2,26 +2,55 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"image"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 
 	"9fans.net/go/draw"
 	"github.com/mjl-/duit"
 )
 
 type fileUI struct {
+	id                 int // for the file system
 	column             *columnUI
-	file               *os.File // can be nil
+	file               *diskFile   // can be nil
+	source             *bodySource // what the body reads from, shared with zerox windows
 	square             *square
 	header, body       *duit.Edit
 	headerBox, bodyBox *duit.Box
+	addr               span       // for the addr, data and xdata files
+	events             eventQueue // for the event file
+	deleted            bool
+	waiters            []chan struct{} // closed when the window is deleted
+	clones             *zerox          // if not nil, other windows showing the same text
+	warned             bool            // modified, but listed in +Errors when closing, so closing again goes ahead
+	stat               os.FileInfo     // of the file when read or written, nil if not a file on disk
+	putWarned          bool            // file changed on disk, the next Put overwrites it anyway
+	staleReported      bool            // file changed in place, reported in +Errors
+	tagFit             tagFit
+	font               *draw.Font // of the body, nil for the default font
+	indent             bool       // autoindent new lines in the body
+	win                *winProc   // for win windows, the shell
+	edits              int        // events that may have changed the body
+	vi                 viState    // mode of the body
+	hist               bodyHist   // last change of the body
+	keyed              keyChange  // how the key the body is handling may change it
 	duit.Box
 }
 
+var lastWindowID int
+
+// pendingSaves tracks files being written, Exit waits for them.
+var pendingSaves sync.WaitGroup
+
 func newFileUI(column *columnUI, filename string) *fileUI {
 	slash := strings.HasSuffix(filename, "/")
 	if filename != "" {
@@ -42,10 +71,12 @@ func newFileUI(column *columnUI, filename string) *fileUI {
 	header.SetCursor(duit.Cursor{Cur: taglen, Start: taglen})
 	header.Colors = tagColors
 	header.NoScrollbar = true
-	height := tagHeight()
+	lastWindowID++
 	ui := &fileUI{
+		id:     lastWindowID,
 		column: column,
 		header: header,
+		indent: autoindent,
 	}
 	ui.square = &square{
 		dirty:       false,
@@ -61,7 +92,10 @@ func newFileUI(column *columnUI, filename string) *fileUI {
 		b3: func() {
 			ui.column.growFull(ui)
 		},
-		lowdpiSize: image.Pt(duit.ScrollbarSize, height),
+		drop: func(p image.Point) {
+			topUI.moveFile(ui, p)
+		},
+		lowdpiSize: image.Pt(duit.ScrollbarSize, tagHeight()),
 	}
 	headerSplit := &duit.Split{
 		Split: func(width int) []int {
@@ -71,27 +105,30 @@ func newFileUI(column *columnUI, filename string) *fileUI {
 		Kids: duit.NewKids(ui.square, header),
 	}
 	ui.headerBox = &duit.Box{
-		Height: height,
 		Width:  -1,
+		Height: -1,
 		Kids:   duit.NewKids(headerSplit),
 	}
 	ui.bodyBox = &duit.Box{
-		Width: -1,
-		Kids:  duit.NewKids(ui.body),
+		Width:  -1,
+		Height: -1,
+		Kids:   duit.NewKids(ui.body),
 	}
 	ui.init(filename)
-	ui.Box.Margin = image.Pt(0, 1)
 	ui.Box.Background = squareBorderColor
-	ui.Box.Width = -1
 	ui.Box.Kids = duit.NewKids(ui.headerBox, ui.bodyBox)
 
 	ui.header.Click = func(m draw.Mouse, offset int64) (e duit.Event) {
 		switch m.Buttons {
 		case duit.Button1:
 		case duit.Button2:
-			ui.execute(expandText(ui.header, offset))
+			if !ui.clickEvent('X', ui.header, offset) {
+				ui.execute(expandText(ui.header, offset))
+			}
 		case duit.Button3:
-			ui.look(expandText(ui.header, offset))
+			if !ui.clickEvent('L', ui.header, offset) {
+				ui.look(expandText(ui.header, offset))
+			}
 		}
 		return
 	}
@@ -106,41 +143,94 @@ func newFileUI(column *columnUI, filename string) *fileUI {
 	return ui
 }
 
+// init reads the body from filename, a file or directory, or starts with an empty body.
 func (ui *fileUI) init(filename string) {
-	if filename != "" && !strings.HasSuffix(filename, "/+Errors") {
+	ui.reset()
+	var text []byte
+	if filename != "" && !scratch(filename) {
 		fi, err := os.Stat(filename)
-		if topUI.error(filename, err, "stat") {
-			return
-		}
-		if fi.IsDir() {
-			files, err := ioutil.ReadDir(filename)
+		if os.IsNotExist(err) {
+			// new file, start with an empty body
+		} else if topUI.error(filename, err, "stat") {
+			// empty body
+		} else if fi.IsDir() {
+			buf, err := dirListing(filename)
 			if !topUI.error(filename, err, "readdir") {
-				s := ""
-				for _, f := range files {
-					s += f.Name()
-					if f.IsDir() {
-						s += "/"
-					}
-					s += "\n"
-				}
-				ui.body, _ = duit.NewEdit(bytes.NewReader([]byte(s)))
+				text = buf
+				watchDir(ui, path.Clean(filename))
 			}
 		} else {
-			ui.file, err = os.Open(filename)
-			if err == nil {
-				ui.body, err = duit.NewEdit(ui.file)
+			ui.file, ui.stat, err = openDiskFile(filename)
+			if !topUI.error(filename, err, "init") {
+				ui.source = &bodySource{ui.file}
+				ui.initBody()
+				wakeWatch()
+				return
 			}
-			topUI.error(filename, err, "init")
 		}
 	}
-	if ui.body == nil {
-		ui.body, _ = duit.NewEdit(bytes.NewReader([]byte("")))
+	ui.source = &bodySource{bytes.NewReader(text)}
+	ui.initBody()
+}
+
+// initText starts the body with text that is not backed by a file.
+func (ui *fileUI) initText(text []byte) {
+	ui.reset()
+	ui.source = &bodySource{bytes.NewReader(text)}
+	ui.initBody()
+}
+
+// initShared starts the body reading from the same file or text as o, e.g. for zerox windows.
+// The text is that of o when it was last read or saved.
+func (ui *fileUI) initShared(o *fileUI) {
+	ui.reset()
+	ui.file = o.file
+	if ui.file != nil {
+		ui.file.refs++
 	}
+	ui.source = o.source
+	ui.stat = o.stat
+	ui.initBody()
+}
+
+func (ui *fileUI) reset() {
+	ui.closeFile()
+	ui.source = nil
+	ui.stat = nil
+	ui.putWarned = false
+	ui.staleReported = false
+	unwatchDir(ui)
+}
+
+// closeFile releases the file of the body.
+func (ui *fileUI) closeFile() {
+	if ui.file != nil {
+		ui.file.release()
+		ui.file = nil
+	}
+}
+
+// initBody creates the body reading from ui.source.
+func (ui *fileUI) initBody() {
+	ui.body, _ = duit.NewEdit(ui.source)
+	ui.vi = viState{}
 	ui.body.Colors = textColors
+	ui.body.Font = ui.font
 	ui.body.Keys = func(k rune, m draw.Mouse) (r duit.Event) {
-		if k == control&'f' {
+		if ui.stale() && !viewKey(k) {
+			topUI.error(ui.path(), errStale, "edit")
+			r.Consumed = true
+		} else if k == control&'f' {
 			topUI.complete(ui.path(), ui.body)
 			r.Consumed = true
+		} else if ui.win != nil && ui.win.key(ui, k) || ui.autoindent(k) {
+			r.Consumed = true
+		} else {
+			c := ui.body.Cursor()
+			mode := ui.vi.mode
+			cmd := ui.vi.key(k, c.Cur == c.Start)
+			modify, undo, repeat := viKeyChange(mode, k, cmd)
+			ui.keyChange(mode, k, modify, undo, repeat)
 		}
 		return
 	}
@@ -148,9 +238,13 @@ func (ui *fileUI) init(filename string) {
 		switch m.Buttons {
 		case duit.Button1:
 		case duit.Button2:
-			ui.execute(expandText(ui.body, offset))
+			if !ui.clickEvent('X', ui.body, offset) {
+				ui.execute(expandText(ui.body, offset))
+			}
 		case duit.Button3:
-			ui.look(expandText(ui.body, offset))
+			if !ui.clickEvent('L', ui.body, offset) {
+				ui.look(expandText(ui.body, offset))
+			}
 		}
 		return
 	}
@@ -159,6 +253,47 @@ func (ui *fileUI) init(filename string) {
 		dui.MarkDraw(ui.square)
 	}
 	ui.bodyBox.Kids[0].UI = ui.body
+	ui.resetHist()
+}
+
+func dirListing(dir string) ([]byte, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := ""
+	for _, f := range files {
+		s += f.Name()
+		if f.IsDir() {
+			s += "/"
+		}
+		s += "\n"
+	}
+	return []byte(s), nil
+}
+
+// refreshDir updates the listing of a clean directory window, keeping the cursor and scroll position.
+func (ui *fileUI) refreshDir() {
+	p := ui.path()
+	if ui.deleted || ui.square.dirty || !strings.HasSuffix(p, "/") {
+		return
+	}
+	buf, err := dirListing(p)
+	if topUI.error(p, err, "readdir") {
+		return
+	}
+	obuf, err := ui.body.Text()
+	if topUI.error(p, err, "read body") || bytes.Equal(obuf, buf) {
+		return
+	}
+	// replacing only the changed part keeps the scroll position
+	o, del, ins := textDiff(obuf, buf)
+	c := ui.body.Cursor()
+	ui.replace('F', ui.body, span{int64(o), int64(o + len(del))}, ins)
+	ui.body.SetCursor(duit.Cursor{Cur: shiftOffset(c.Cur, o, len(del), len(ins)), Start: shiftOffset(c.Start, o, len(del), len(ins))})
+	ui.source.SeekReaderAt = bytes.NewReader(buf)
+	ui.body.Saved()
+	ui.resetHist()
 }
 
 func (ui *fileUI) path() string {
@@ -174,51 +309,206 @@ func (ui *fileUI) save() {
 		topUI.error(p, fmt.Errorf("is a directory"), "save")
 		return
 	}
-	if strings.HasSuffix(p, "/+Errors") {
-		topUI.error(p, fmt.Errorf("is +Errors"), "save")
+	if scratch(p) {
+		topUI.error(p, fmt.Errorf("is %s", path.Base(p)), "save")
 		return
 	}
 
-	// todo: overwrite only the parts that have changed, doing as little buffering as possible
-	// todo: get a reader that is independent of the edit state
-	buf, err := ioutil.ReadAll(ui.body.Reader())
-	if topUI.error(p, err, "read") {
+	dst := p
+	if rp, err := filepath.EvalSymlinks(p); err == nil {
+		dst = rp
+	}
+	fi, err := os.Stat(dst)
+	if err != nil && !os.IsNotExist(err) {
+		topUI.error(p, err, "save")
 		return
 	}
-	go func() {
-		f, err := os.Create(p)
-		if topUI.error(p, err, "create") {
-			return
+	if ui.stale() {
+		topUI.error(p, errStale, "save")
+		return
+	}
+	if fi != nil && ui.stat != nil && fileChanged(ui.stat, fi) && !ui.putWarned {
+		ui.putWarned = true
+		topUI.error(p, fmt.Errorf("file changed on disk since read, Put again to overwrite"), "save")
+		return
+	}
+
+	// The edit is copied to the new file in the main loop, it must not change while being read.
+	// Syncing and renaming, the slow part, happen outside it.
+	var f *os.File
+	if fi == nil {
+		f, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	} else {
+		f, err = ioutil.TempFile(path.Dir(dst), "."+path.Base(dst)+".acvi")
+	}
+	if topUI.error(p, err, "save") {
+		return
+	}
+	h := sha256.New()
+	_, err = io.Copy(f, io.TeeReader(ui.body.Reader(), h))
+	if err != nil {
+		f.Close()
+		if fi != nil {
+			os.Remove(f.Name())
 		}
-		_, err = f.Write(buf)
-		if topUI.error(p, err, "write") {
-			err = f.Close()
-			topUI.error(p, err, "close")
-			return
+		topUI.error(p, err, "save")
+		return
+	}
+	sum := h.Sum(nil)
+	edits := ui.zeroxEdits()
+
+	pendingSaves.Add(1)
+	go func() {
+		err := finishSave(f, dst, fi)
+		var nf *diskFile
+		var nfi os.FileInfo
+		if err == nil {
+			nf, nfi, err = openDiskFile(dst)
 		}
-		err = f.Close()
-		topUI.error(p, err, "close")
+		pendingSaves.Done()
 
 		dui.Call <- func() {
-			ui.body.Saved()
-			dui.MarkDraw(ui.body)
+			if topUI.error(p, err, "save") {
+				return
+			}
+			ui.saved(nf, nfi, edits, sum)
 		}
 	}()
 }
 
+// saved makes the zerox windows of ui read from the just written file nf, and marks them clean.
+// If the text changed during the save, the windows stay modified.
+func (ui *fileUI) saved(nf *diskFile, fi os.FileInfo, edits int, sum []byte) {
+	files := ui.zeroxFiles()
+	for _, f := range files {
+		f.stat = fi
+		f.putWarned = false
+	}
+	wakeWatch()
+	if ui.deleted || ui.zeroxEdits() != edits && !bytes.Equal(bodySum(ui), sum) {
+		nf.release()
+		return
+	}
+	// the windows share their source, pointing it to the new file makes them all read from it
+	if ui.file != nil {
+		ui.file.refs = 1
+		ui.file.release()
+	}
+	nf.refs = len(files)
+	ui.source.SeekReaderAt = nf
+	for _, f := range files {
+		f.file = nf
+		// Saved makes the edit read its text from the source again, it also clears the undo history
+		f.body.Saved()
+		f.resetHist()
+		f.warned = false
+		dui.MarkDraw(f)
+	}
+}
+
+// zeroxEdits returns the number of events that may have changed the body of ui or its zerox windows.
+func (ui *fileUI) zeroxEdits() int {
+	n := 0
+	for _, f := range ui.zeroxFiles() {
+		n += f.edits
+	}
+	return n
+}
+
+// bodySum returns the sha256 of the body, nil on errors.
+func bodySum(ui *fileUI) []byte {
+	h := sha256.New()
+	if _, err := io.Copy(h, ui.body.Reader()); err != nil {
+		return nil
+	}
+	return h.Sum(nil)
+}
+
+// finishSave syncs f and, if the file existed as fi, gives it the mode and owner of fi and renames it to dst.
+func finishSave(f *os.File, dst string, fi os.FileInfo) error {
+	err := f.Sync()
+	if err == nil && fi != nil {
+		err = f.Chmod(fi.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+		if st, ok := fi.Sys().(*syscall.Stat_t); ok && err == nil {
+			// only root can give files away, the group may work
+			if f.Chown(int(st.Uid), int(st.Gid)) != nil {
+				f.Chown(-1, int(st.Gid))
+			}
+		}
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if fi == nil {
+		return err
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if d, err := os.Open(path.Dir(dst)); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// stale returns whether the file of the body was changed in place, the body cannot be read or changed until Get.
+func (ui *fileUI) stale() bool {
+	return ui.file != nil && ui.file.check()
+}
+
+// viewKey returns whether k only moves the view or cursor in insert mode, it is allowed in stale windows.
+func viewKey(k rune) bool {
+	switch k {
+	case draw.KeyUp, draw.KeyDown, draw.KeyLeft, draw.KeyRight, draw.KeyPageUp, draw.KeyPageDown, draw.KeyCmd + 'c', draw.KeyCmd + 'n':
+		return true
+	}
+	return false
+}
+
+// modified returns whether closing the window loses changes.
+func (ui *fileUI) modified() bool {
+	p := ui.path()
+	return ui.square.dirty && !strings.HasSuffix(p, "/") && !scratch(p)
+}
+
 func (ui *fileUI) del() {
 	ui.column.removeFile(ui)
+	closeWindow(ui)
+	ui.closeFile()
+	ui.removeZerox()
+	unwatchDir(ui)
+	if ui.win != nil {
+		ui.win.close()
+		ui.win = nil
+	}
+	ui.deleted = true
+	ui.events.delete()
+	for _, c := range ui.waiters {
+		close(c)
+	}
+	ui.waiters = nil
 }
 
+// get reloads the body, and that of the zerox windows, from the file.
 func (ui *fileUI) get() {
-	if ui.file != nil {
-		ui.file.Close()
-		ui.file = nil
-	}
-	ui.body = nil
 	ui.init(ui.path())
 	ui.square.dirty = false
-	dui.MarkLayout(ui)
+	for _, f := range ui.zeroxFiles() {
+		if f != ui {
+			c := f.body.Cursor()
+			f.initShared(ui)
+			if size := editSize(f.body); c.Cur <= size && c.Start <= size {
+				f.body.SetCursor(c)
+			}
+			f.square.dirty = false
+		}
+		dui.MarkLayout(f)
+	}
 }
 
 func (ui *fileUI) execute(t string) {
@@ -226,22 +516,84 @@ func (ui *fileUI) execute(t string) {
 	case "Put":
 		ui.save()
 	case "Del":
-		ui.del()
+		if topUI.clean(ui.path(), []*fileUI{ui}) {
+			ui.del()
+		}
 	case "Get":
 		ui.get()
+	case "Zerox":
+		ui.zerox()
+	case "Send":
+		topUI.error(ui.path(), ui.winSend(), "send")
+	case "Undo", "Redo":
+		ui.undo(t == "Redo")
+	case "Snarf", "Cut":
+		c := ui.body.Cursor()
+		sel, err := ui.body.Selection()
+		if topUI.error(ui.path(), err, "selection") || len(sel) == 0 {
+			return
+		}
+		dui.WriteSnarf(sel)
+		if t == "Cut" {
+			c0, c1 := c.Ordered()
+			ui.replace('M', ui.body, span{c0, c1}, nil)
+			ui.body.SetCursor(duit.Cursor{Cur: c0, Start: c0})
+		}
+	case "Paste":
+		buf, ok := dui.ReadSnarf()
+		if !ok {
+			return
+		}
+		c0, c1 := ui.body.Cursor().Ordered()
+		ui.replace('M', ui.body, span{c0, c1}, buf)
+		ui.body.SetCursor(duit.Cursor{Cur: c0 + int64(len(buf)), Start: c0})
 	default:
 		ui.column.execute(ui.path(), t, ui.body)
 	}
 }
 
-func (ui *fileUI) look(t string) {
-	if t == "" {
+// bodyKey delivers k to the body as if typed with the mouse in the body, for keys handled by duit.Edit itself.
+func (ui *fileUI) bodyKey(k rune) {
+	kid := ui.bodyBox.Kids[0]
+	m := draw.Mouse{Point: image.Pt(kid.R.Dx()*3/4, kid.R.Dy()/2)}
+	ui.keyed = keyChange{}
+	ui.body.Key(dui, kid, k, m, image.ZP)
+	ui.bodyKeyed()
+	dui.MarkDraw(ui)
+}
+
+// undo undoes or redoes the last change to the body, with the key for the mode the body is in.
+func (ui *fileUI) undo(redo bool) {
+	var k rune
+	switch {
+	case ui.vi.insert() && redo:
+		k = draw.KeyCmd + 'Z'
+	case ui.vi.insert():
+		k = draw.KeyCmd + 'z'
+	case ui.vi.command() && redo:
+		k = control & 'r'
+	case ui.vi.command():
+		k = 'u'
+	default:
+		topUI.error(ui.path(), errViBusy, "undo")
 		return
 	}
-	if topUI.look(ui.path(), t, true) {
+	ui.bodyKey(k)
+}
+
+func (ui *fileUI) look(t string) {
+	if t == "" {
 		return
 	}
+	topUI.plumb(ui.path(), t, func() {
+		if !ui.deleted && !topUI.look(ui.path(), t, true) {
+			ui.search(t)
+		}
+	})
+}
 
+// search selects the next occurrence of t in the body.
+func (ui *fileUI) search(t string) {
 	ui.body.LastSearch = " " + t
 	if ui.body.Search(dui, false) {
 		ui.body.ScrollCursor(dui)
@@ -253,12 +605,17 @@ func (ui *fileUI) look(t string) {
 }
 
 func (ui *fileUI) append(buf []byte) {
-	ui.body.Append(buf)
+	size := editSize(ui.body)
+	ui.replace('F', ui.body, span{size, size}, buf)
+	end := size + int64(len(buf))
+	ui.body.SetCursor(duit.Cursor{Cur: end, Start: end})
 	ui.body.ScrollCursor(dui)
 	dui.MarkDraw(ui)
 }
 
 func (ui *fileUI) Key(dui *duit.DUI, self *duit.Kid, k rune, m draw.Mouse, orig image.Point) (r duit.Result) {
+	touchWindow(ui)
+	ui.edits++
 	switch k {
 	case draw.KeyCmd + 't':
 		dui.Focus(ui.header)
@@ -268,19 +625,78 @@ func (ui *fileUI) Key(dui *duit.DUI, self *duit.Kid, k rune, m draw.Mouse, orig
 		ui.save()
 		dui.MarkDraw(ui)
 	case draw.KeyCmd + 'w':
-		ui.del()
-		dui.MarkLayout(ui)
+		if topUI.clean(ui.path(), []*fileUI{ui}) {
+			ui.del()
+			dui.MarkLayout(ui)
+		}
 	case draw.KeyCmd + 'e':
 		ui.execute(buttonText(ui.header))
+	case draw.KeyCmd + '<':
+		topUI.moveFileColumn(ui, -1)
+	case draw.KeyCmd + '>':
+		topUI.moveFileColumn(ui, 1)
 	default:
-		return ui.Box.Key(dui, self, k, m, orig)
+		ui.warned = false
+		ui.keyed = keyChange{}
+		if !ui.events.listening() {
+			r = ui.Box.Key(dui, self, k, m, orig)
+			ui.bodyKeyed()
+			return
+		}
+		otag, err := ui.header.Text()
+		if topUI.error(ui.path(), err, "read tag") {
+			return
+		}
+		r = ui.Box.Key(dui, self, k, m, orig)
+		ui.bodyKeyed()
+		ui.tagEvents(otag)
+		return
 	}
 	r.Consumed = true
 	return
 }
 
+func (ui *fileUI) Layout(dui *duit.DUI, self *duit.Kid, sizeAvail image.Point, force bool) {
+	if duit.KidsLayout(dui, self, ui.Kids, force) {
+		return
+	}
+	ui.tagFit = layoutTag(dui, self, ui.Kids, ui.header, sizeAvail, dui.Scale(1))
+}
+
+func (ui *fileUI) Draw(dui *duit.DUI, self *duit.Kid, img *draw.Image, orig image.Point, m draw.Mouse, force bool) {
+	duit.KidsDraw(dui, self, ui.Kids, self.R.Size(), ui.Box.Background, img, orig, m, force)
+}
+
+func (ui *fileUI) Mouse(dui *duit.DUI, self *duit.Kid, m draw.Mouse, origM draw.Mouse, orig image.Point) duit.Result {
+	if m.Buttons != 0 {
+		touchWindow(ui)
+		ui.edits++
+	}
+	if m.Buttons&duit.Button1 != 0 && m.Buttons&(duit.Button2|duit.Button3) != 0 && origM.In(ui.Kids[1].R) && ui.stale() {
+		// cut and paste chords would change the body
+		topUI.error(ui.path(), errStale, "edit")
+		return duit.Result{Consumed: true}
+	}
+	if r := ui.bodyTextRect(); origM.In(r) {
+		ui.vi.mouse(m.Buttons)
+	}
+	r := ui.Box.Mouse(dui, self, m, origM, orig)
+	if ui.win != nil {
+		ui.win.sendLines(ui)
+	}
+	return r
+}
+
+// bodyTextRect returns the area of the text of the body, without its scrollbar.
+func (ui *fileUI) bodyTextRect() image.Rectangle {
+	r := ui.bodyBox.Kids[0].R.Add(ui.Kids[1].R.Min)
+	r.Min.X += dui.Scale(duit.ScrollbarSize)
+	return r
+}
+
 func (ui *fileUI) Focus(dui *duit.DUI, self *duit.Kid, o duit.UI) *image.Point {
 	if o == ui {
+		touchWindow(ui)
 		p := ui.bodyBox.Focus(dui, ui.Kids[1], ui.body)
 		pp := p.Add(ui.Kids[1].R.Min)
 		return &pp
@@ -289,9 +705,12 @@ func (ui *fileUI) Focus(dui *duit.DUI, self *duit.Kid, o duit.UI) *image.Point {
 	if p == nil {
 		p = ui.bodyBox.Focus(dui, ui.Kids[1], o)
 		if p != nil {
+			touchWindow(ui)
 			pp := p.Add(ui.Kids[1].R.Min)
 			return &pp
 		}
+	} else {
+		touchWindow(ui)
 	}
 	return p
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"9fans.net/go/draw"
+)
+
+// Windows show their body in the variable-width font, from -f or $font, or the fixed-width font, from -F or $fixedfont.
+// Font in a tag switches the window between them, "Font file" uses the font in file.
+
+var (
+	fixedFontName string
+	openFonts     = map[string]*draw.Font{}
+)
+
+func openFont(name string) (*draw.Font, error) {
+	if f, ok := openFonts[name]; ok {
+		return f, nil
+	}
+	f, err := dui.Display.OpenFont(name)
+	if err != nil {
+		return nil, err
+	}
+	openFonts[name] = f
+	return f, nil
+}
+
+// setFont changes the font of the body. Without arguments, it switches between the variable-width and fixed-width font.
+func (ui *fileUI) setFont(args []string) error {
+	var font *draw.Font
+	var err error
+	switch {
+	case len(args) > 1:
+		return fmt.Errorf("usage: Font [file]")
+	case len(args) == 1:
+		font, err = openFont(args[0])
+	case ui.font != nil:
+		// back to the variable-width font, the default
+	case fixedFontName == "":
+		return fmt.Errorf("no fixed-width font, set $fixedfont or use -F")
+	default:
+		font, err = openFont(fixedFontName)
+	}
+	if err != nil {
+		return err
+	}
+	ui.font = font
+	ui.body.Font = font
+	dui.MarkLayout(ui)
+	return nil
+}
@@ -0,0 +1,1032 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/mjl-/duit"
+)
+
+// The file system is compatible with acme's, so acme clients can control acvi.
+// Its root has "index", "new/" and a directory for each window.
+
+const (
+	qroot = iota
+	qindex
+	qnew
+	qwindir
+	qaddr
+	qbody
+	qctl
+	qdata
+	qevent
+	qtag
+	qxdata
+)
+
+var winFiles = []struct {
+	name string
+	q    int
+}{
+	{"addr", qaddr},
+	{"body", qbody},
+	{"ctl", qctl},
+	{"data", qdata},
+	{"event", qevent},
+	{"tag", qtag},
+	{"xdata", qxdata},
+}
+
+var builtins = map[string]bool{
+	"Cd":     true,
+	"Cut":    true,
+	"Del":    true,
+	"Delall": true,
+	"Delcol": true,
+	"Dump":   true,
+	"Edit":   true,
+	"Exit":   true,
+	"Font":   true,
+	"Get":    true,
+	"Indent": true,
+	"Jobs":   true,
+	"Kill":   true,
+	"Load":   true,
+	"New":    true,
+	"Newcol": true,
+	"Next":   true,
+	"Open":   true,
+	"Paste":  true,
+	"Prev":   true,
+	"Put":    true,
+	"Putall": true,
+	"Redo":   true,
+	"Send":   true,
+	"Snarf":  true,
+	"Undo":   true,
+	"Win":    true,
+	"Zerox":  true,
+}
+
+func isBuiltin(cmd string) bool {
+	t := strings.Fields(cmd)
+	return len(t) > 0 && builtins[t[0]]
+}
+
+var fsysListener net.Listener
+
+// startFsys posts the file system as "acme" in the namespace directory, and serves it in the background.
+func startFsys() {
+	dir := namespace()
+	os.MkdirAll(dir, 0700)
+	addr := dir + "/acme"
+	if c, err := net.Dial("unix", addr); err == nil {
+		c.Close()
+		log.Printf("fsys: %s already in use, not serving file system\n", addr)
+		return
+	}
+	os.Remove(addr)
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		log.Printf("fsys: listen: %s\n", err)
+		return
+	}
+	fsysListener = l
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go newFsConn(c).serve()
+		}
+	}()
+}
+
+// eventQueue holds the events for readers of the event file of a window.
+type eventQueue struct {
+	sync.Mutex
+	nopen int
+	buf   []byte
+	wait  chan struct{} // closed when events are added or the window is deleted
+	gone  bool
+}
+
+func (q *eventQueue) listening() bool {
+	q.Lock()
+	defer q.Unlock()
+	return q.nopen > 0
+}
+
+func (q *eventQueue) open() {
+	q.Lock()
+	q.nopen++
+	q.Unlock()
+}
+
+func (q *eventQueue) close() {
+	q.Lock()
+	q.nopen--
+	if q.nopen == 0 {
+		q.buf = nil
+	}
+	q.Unlock()
+}
+
+func (q *eventQueue) wake() {
+	if q.wait != nil {
+		close(q.wait)
+		q.wait = nil
+	}
+}
+
+func (q *eventQueue) post(s string) {
+	q.Lock()
+	defer q.Unlock()
+	if q.nopen == 0 {
+		return
+	}
+	q.buf = append(q.buf, s...)
+	q.wake()
+}
+
+func (q *eventQueue) delete() {
+	q.Lock()
+	q.gone = true
+	q.wake()
+	q.Unlock()
+}
+
+// read blocks until events are available, or flush is closed.
+func (q *eventQueue) read(n int, flush chan struct{}) ([]byte, error) {
+	for {
+		q.Lock()
+		if len(q.buf) > 0 {
+			n = minimum(n, len(q.buf))
+			buf := q.buf[:n]
+			q.buf = q.buf[n:]
+			q.Unlock()
+			return buf, nil
+		}
+		if q.gone {
+			q.Unlock()
+			return nil, fmt.Errorf("window deleted")
+		}
+		if q.wait == nil {
+			q.wait = make(chan struct{})
+		}
+		wait := q.wait
+		q.Unlock()
+
+		select {
+		case <-wait:
+		case <-flush:
+			return nil, fmt.Errorf("interrupted")
+		}
+	}
+}
+
+type fsFid struct {
+	q     int
+	win   *fileUI // window for files in window directories, nil otherwise
+	isnew bool    // in new/, opening creates a window
+	open  bool
+}
+
+func (fid *fsFid) qid() qid {
+	var id int
+	if fid.win != nil {
+		id = fid.win.id
+	}
+	q := qid{Path: uint64(id)<<8 | uint64(fid.q)}
+	if fid.isDir() {
+		q.Type = qtdir
+	}
+	return q
+}
+
+func (fid *fsFid) isDir() bool {
+	return fid.q == qroot || fid.q == qnew || fid.q == qwindir
+}
+
+func (fid *fsFid) name() string {
+	switch fid.q {
+	case qroot:
+		return "/"
+	case qindex:
+		return "index"
+	case qnew:
+		return "new"
+	case qwindir:
+		return fmt.Sprintf("%d", fid.win.id)
+	}
+	for _, f := range winFiles {
+		if f.q == fid.q {
+			return f.name
+		}
+	}
+	return "?"
+}
+
+func (fid *fsFid) stat(user string) []byte {
+	mode := uint32(0600)
+	if fid.isDir() {
+		mode = dmdir | 0500
+	} else if fid.q == qindex {
+		mode = 0400
+	}
+	return statBytes(fid.name(), fid.qid(), mode, 0, user)
+}
+
+type fsRequest struct {
+	flush chan struct{} // closed when the request is flushed
+	done  chan struct{} // closed when the request has been handled
+}
+
+type fsConn struct {
+	conn  net.Conn
+	user  string
+	wlock sync.Mutex // for writing responses
+
+	sync.Mutex // protects fields below
+	msize      uint32
+	fids       map[uint32]*fsFid
+	pending    map[uint16]*fsRequest
+	last       map[uint32]chan struct{} // per fid, closed when its last request is done
+}
+
+func newFsConn(conn net.Conn) *fsConn {
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "none"
+	}
+	return &fsConn{
+		conn:    conn,
+		user:    user,
+		msize:   maxMsg,
+		fids:    map[uint32]*fsFid{},
+		pending: map[uint16]*fsRequest{},
+		last:    map[uint32]chan struct{}{},
+	}
+}
+
+func (c *fsConn) serve() {
+	defer c.conn.Close()
+	for {
+		f, err := readFcall(c.conn)
+		if err != nil {
+			if err != io.EOF {
+				log.Printf("fsys: read: %s\n", err)
+			}
+			break
+		}
+		if f.Type == tflush {
+			go c.flush(f)
+			continue
+		}
+		req := &fsRequest{make(chan struct{}), make(chan struct{})}
+		c.Lock()
+		c.pending[f.Tag] = req
+		c.Unlock()
+		if f.Type == tversion || f.Type == tauth {
+			go c.handle(f, req)
+		} else {
+			c.queue(f, req)
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	for _, fid := range c.fids {
+		c.clunk(fid)
+	}
+	c.fids = nil
+}
+
+// queue handles f after the earlier requests for its fid, so requests on a fid are handled in order.
+// Requests on different fids, e.g. a read of an event file that waits for events, do not wait for each other.
+func (c *fsConn) queue(f *fcall, req *fsRequest) {
+	done := make(chan struct{})
+	c.Lock()
+	prev := c.last[f.Fid]
+	c.last[f.Fid] = done
+	c.Unlock()
+	go func() {
+		defer func() {
+			c.Lock()
+			if c.last[f.Fid] == done {
+				delete(c.last, f.Fid)
+			}
+			c.Unlock()
+			close(done)
+		}()
+		if prev != nil {
+			select {
+			case <-prev:
+			case <-req.flush:
+				// flushed before it started, later requests still wait for prev
+				close(req.done)
+				<-prev
+				return
+			}
+		}
+		c.handle(f, req)
+	}()
+}
+
+func (c *fsConn) write(f *fcall) {
+	c.wlock.Lock()
+	defer c.wlock.Unlock()
+	if _, err := c.conn.Write(f.bytes()); err != nil {
+		log.Printf("fsys: write: %s\n", err)
+	}
+}
+
+func (c *fsConn) flush(f *fcall) {
+	c.Lock()
+	req := c.pending[f.Oldtag]
+	delete(c.pending, f.Oldtag)
+	c.Unlock()
+	if req != nil {
+		close(req.flush)
+		<-req.done
+	}
+	c.write(&fcall{Type: rflush, Tag: f.Tag})
+}
+
+func (c *fsConn) handle(f *fcall, req *fsRequest) {
+	defer close(req.done)
+	r, err := c.dispatch(f, req.flush)
+	if err != nil {
+		r = &fcall{Type: rerror, Ename: err.Error()}
+	}
+	r.Tag = f.Tag
+
+	c.Lock()
+	if c.pending[f.Tag] == req {
+		delete(c.pending, f.Tag)
+	}
+	c.Unlock()
+	select {
+	case <-req.flush:
+		return
+	default:
+	}
+	c.write(r)
+}
+
+func (c *fsConn) fid(fid uint32) (*fsFid, error) {
+	c.Lock()
+	defer c.Unlock()
+	f := c.fids[fid]
+	if f == nil {
+		return nil, fmt.Errorf("unknown fid")
+	}
+	return f, nil
+}
+
+func (c *fsConn) clunk(fid *fsFid) {
+	if fid.open && fid.q == qevent && fid.win != nil {
+		fid.win.events.close()
+	}
+}
+
+func (c *fsConn) dispatch(f *fcall, flush chan struct{}) (*fcall, error) {
+	switch f.Type {
+	case tversion:
+		c.Lock()
+		for _, fid := range c.fids {
+			c.clunk(fid)
+		}
+		c.fids = map[uint32]*fsFid{}
+		c.msize = maxMsg
+		if f.Msize < c.msize {
+			c.msize = f.Msize
+		}
+		msize := c.msize
+		c.Unlock()
+		version := "unknown"
+		if strings.HasPrefix(f.Version, "9P2000") {
+			version = "9P2000"
+		}
+		return &fcall{Type: rversion, Msize: msize, Version: version}, nil
+
+	case tauth:
+		return nil, fmt.Errorf("acvi: authentication not required")
+
+	case tattach:
+		fid := &fsFid{q: qroot}
+		c.Lock()
+		defer c.Unlock()
+		if c.fids[f.Fid] != nil {
+			return nil, fmt.Errorf("fid in use")
+		}
+		c.fids[f.Fid] = fid
+		return &fcall{Type: rattach, Qid: fid.qid()}, nil
+
+	case twalk:
+		return c.walk(f)
+
+	case topen:
+		return c.open(f)
+
+	case tcreate:
+		return nil, fmt.Errorf("permission denied")
+
+	case tread:
+		fid, err := c.fid(f.Fid)
+		if err != nil {
+			return nil, err
+		}
+		if !fid.open {
+			return nil, fmt.Errorf("fid not open")
+		}
+		c.Lock()
+		count := minimum(int(f.Count), int(c.msize)-iohdr)
+		c.Unlock()
+		data, err := c.read(fid, int64(f.Offset), count, flush)
+		if err != nil {
+			return nil, err
+		}
+		return &fcall{Type: rread, Data: data}, nil
+
+	case twrite:
+		fid, err := c.fid(f.Fid)
+		if err != nil {
+			return nil, err
+		}
+		if !fid.open {
+			return nil, fmt.Errorf("fid not open")
+		}
+		if err := c.write1(fid, f.Data); err != nil {
+			return nil, err
+		}
+		return &fcall{Type: rwrite, Count: uint32(len(f.Data))}, nil
+
+	case tclunk, tremove:
+		c.Lock()
+		defer c.Unlock()
+		fid := c.fids[f.Fid]
+		if fid == nil {
+			return nil, fmt.Errorf("unknown fid")
+		}
+		c.clunk(fid)
+		delete(c.fids, f.Fid)
+		if f.Type == tremove {
+			return nil, fmt.Errorf("permission denied")
+		}
+		return &fcall{Type: rclunk}, nil
+
+	case tstat:
+		fid, err := c.fid(f.Fid)
+		if err != nil {
+			return nil, err
+		}
+		return &fcall{Type: rstat, Stat: fid.stat(c.user)}, nil
+
+	case twstat:
+		return nil, fmt.Errorf("permission denied")
+	}
+	return nil, fmt.Errorf("bad message type")
+}
+
+func (c *fsConn) walk(f *fcall) (*fcall, error) {
+	fid, err := c.fid(f.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if fid.open {
+		return nil, fmt.Errorf("walk of open fid")
+	}
+	nfid := *fid
+	var wqids []qid
+	for i, name := range f.Wname {
+		if !c.walk1(&nfid, name) {
+			if i == 0 {
+				return nil, fmt.Errorf("file does not exist")
+			}
+			break
+		}
+		wqids = append(wqids, nfid.qid())
+	}
+	if len(wqids) == len(f.Wname) {
+		c.Lock()
+		defer c.Unlock()
+		if f.Newfid != f.Fid && c.fids[f.Newfid] != nil {
+			return nil, fmt.Errorf("fid in use")
+		}
+		c.fids[f.Newfid] = &nfid
+	}
+	return &fcall{Type: rwalk, Wqid: wqids}, nil
+}
+
+func (c *fsConn) walk1(fid *fsFid, name string) bool {
+	if name == ".." {
+		fid.q = qroot
+		fid.win = nil
+		fid.isnew = false
+		return true
+	}
+	switch fid.q {
+	case qroot:
+		switch name {
+		case "index":
+			fid.q = qindex
+			return true
+		case "new":
+			fid.q = qnew
+			fid.isnew = true
+			return true
+		}
+		id, err := strconv.Atoi(name)
+		if err != nil {
+			return false
+		}
+		var win *fileUI
+		uiCall(func() {
+			win = topUI.findWindow(id)
+		})
+		if win == nil {
+			return false
+		}
+		fid.q = qwindir
+		fid.win = win
+		return true
+	case qnew, qwindir:
+		for _, f := range winFiles {
+			if f.name == name {
+				fid.q = f.q
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c *fsConn) open(f *fcall) (*fcall, error) {
+	fid, err := c.fid(f.Fid)
+	if err != nil {
+		return nil, err
+	}
+	if fid.open {
+		return nil, fmt.Errorf("fid already open")
+	}
+	mode := f.Mode &^ otrunc
+	if (fid.isDir() || fid.q == qindex) && mode != oread {
+		return nil, fmt.Errorf("permission denied")
+	}
+	if fid.isnew && !fid.isDir() {
+		uiCall(func() {
+			fid.win = topUI.columns[len(topUI.columns)-1].addFile("")
+		})
+		fid.isnew = false
+	}
+	if fid.win != nil {
+		uiCall(func() {
+			if fid.win.deleted {
+				err = fmt.Errorf("window deleted")
+				return
+			}
+			if fid.q == qaddr {
+				fid.win.addr = span{0, 0}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if fid.q == qevent {
+			uiCall(func() {
+				if !fid.win.tracking() {
+					fid.win.syncHist()
+				}
+				fid.win.events.open()
+			})
+		}
+	}
+	fid.open = true
+	c.Lock()
+	iounit := c.msize - iohdr
+	c.Unlock()
+	return &fcall{Type: ropen, Qid: fid.qid(), Iounit: iounit}, nil
+}
+
+func (c *fsConn) read(fid *fsFid, offset int64, count int, flush chan struct{}) (data []byte, err error) {
+	switch fid.q {
+	case qroot, qnew, qwindir:
+		var l []*fsFid
+		switch fid.q {
+		case qroot:
+			l = []*fsFid{{q: qindex}, {q: qnew}}
+			uiCall(func() {
+				for _, w := range topUI.windows() {
+					l = append(l, &fsFid{q: qwindir, win: w})
+				}
+			})
+		default:
+			for _, f := range winFiles {
+				l = append(l, &fsFid{q: f.q, win: fid.win})
+			}
+		}
+		return dirRead(l, offset, count, c.user), nil
+
+	case qindex:
+		var buf []byte
+		uiCall(func() {
+			for _, w := range topUI.windows() {
+				buf = append(buf, w.indexLine()...)
+			}
+		})
+		if offset >= int64(len(buf)) {
+			return nil, nil
+		}
+		buf = buf[offset:]
+		return buf[:minimum(count, len(buf))], nil
+
+	case qevent:
+		return fid.win.events.read(count, flush)
+	}
+
+	uiCall(func() {
+		if fid.win.deleted {
+			err = fmt.Errorf("window deleted")
+			return
+		}
+		data, err = fid.win.fsRead(fid.q, offset, count)
+	})
+	return
+}
+
+func (c *fsConn) write1(fid *fsFid, data []byte) (err error) {
+	uiCall(func() {
+		if fid.win == nil {
+			err = fmt.Errorf("permission denied")
+			return
+		}
+		if fid.win.deleted {
+			err = fmt.Errorf("window deleted")
+			return
+		}
+		err = fid.win.fsWrite(fid.q, data)
+	})
+	return
+}
+
+// dirRead returns the stat entries of l that start at offset, as far as they fit in count.
+func dirRead(l []*fsFid, offset int64, count int, user string) []byte {
+	var buf []byte
+	o := int64(0)
+	for _, fid := range l {
+		st := fid.stat(user)
+		if o >= offset {
+			if len(buf)+len(st) > count {
+				break
+			}
+			buf = append(buf, st...)
+		}
+		o += int64(len(st))
+	}
+	return buf
+}
+
+func (ui *mainUI) windows() (l []*fileUI) {
+	for _, col := range ui.columns {
+		l = append(l, col.files.files...)
+	}
+	sort.Slice(l, func(i, j int) bool {
+		return l[i].id < l[j].id
+	})
+	return
+}
+
+func (ui *mainUI) findWindow(id int) *fileUI {
+	for _, col := range ui.columns {
+		for _, f := range col.files.files {
+			if f.id == id {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// editSize returns the size of the text in edit.
+func editSize(edit *duit.Edit) int64 {
+	// Reader returns an io.SectionReader, which knows its size.
+	return edit.Reader().(interface{ Size() int64 }).Size()
+}
+
+func (ui *fileUI) tagText() string {
+	t, err := ui.header.Text()
+	topUI.error(ui.path(), err, "read tag")
+	return string(t)
+}
+
+func (ui *fileUI) isDir() bool {
+	return strings.HasSuffix(ui.path(), "/")
+}
+
+func (ui *fileUI) ctlPrint() string {
+	isdir := 0
+	if ui.isDir() {
+		isdir = 1
+	}
+	dirty := 0
+	if ui.square.dirty {
+		dirty = 1
+	}
+	tag := ui.header
+	body := ui.body
+	font := dui.Font(body.Font)
+	return fmt.Sprintf("%11d %11d %11d %11d %11d %11d %q %11d ", ui.id, runeOffset(tag, editSize(tag)), runeOffset(body, editSize(body)), isdir, dirty, ui.Kids[1].R.Dx(), font.Name, 8*font.StringWidth("0"))
+}
+
+func (ui *fileUI) indexLine() string {
+	s := ui.ctlPrint()
+	return s[:5*12] + strings.Split(ui.tagText(), "\n")[0] + "\n"
+}
+
+// fsRead reads from one of the files in the window directory.
+func (ui *fileUI) fsRead(q int, offset int64, count int) ([]byte, error) {
+	var buf []byte
+	switch q {
+	case qaddr:
+		buf = []byte(fmt.Sprintf("%11d %11d ", runeOffset(ui.body, ui.addr.q0), runeOffset(ui.body, ui.addr.q1)))
+	case qctl:
+		buf = []byte(ui.ctlPrint())
+	case qbody, qtag:
+		edit := ui.body
+		if q == qtag {
+			edit = ui.header
+		}
+		buf = make([]byte, count)
+		n, err := readAtFull(edit.Reader(), buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return buf[:n], nil
+	case qdata, qxdata:
+		end := editSize(ui.body)
+		if q == qxdata {
+			end = ui.addr.q1
+		}
+		n := minimum(count, int(maximum64(0, end-ui.addr.q0)))
+		buf = make([]byte, n)
+		n, err := readAtFull(ui.body.Reader(), buf, ui.addr.q0)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		buf = buf[:n]
+		// don't return partial characters
+		for i := 1; i < utf8.UTFMax && i <= len(buf); i++ {
+			if utf8.RuneStart(buf[len(buf)-i]) {
+				if !utf8.FullRune(buf[len(buf)-i:]) {
+					buf = buf[:len(buf)-i]
+				}
+				break
+			}
+		}
+		ui.addr.q0 += int64(len(buf))
+		if ui.addr.q1 < ui.addr.q0 {
+			ui.addr.q1 = ui.addr.q0
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("permission denied")
+	}
+	if offset >= int64(len(buf)) {
+		return nil, nil
+	}
+	buf = buf[offset:]
+	return buf[:minimum(count, len(buf))], nil
+}
+
+// fsWrite writes to one of the files in the window directory.
+func (ui *fileUI) fsWrite(q int, data []byte) error {
+	switch q {
+	case qaddr:
+		text, err := ui.body.Text()
+		if err != nil {
+			return err
+		}
+		addr, err := evalAddr(text, ui.addr, strings.TrimSpace(string(data)))
+		if err != nil {
+			return err
+		}
+		ui.addr = addr
+	case qbody:
+		size := editSize(ui.body)
+		ui.replace('E', ui.body, span{size, size}, data)
+	case qtag:
+		size := editSize(ui.header)
+		ui.replace('E', ui.header, span{size, size}, data)
+	case qdata, qxdata:
+		ui.replace('E', ui.body, ui.addr, data)
+		o := ui.addr.q0 + int64(len(data))
+		ui.addr = span{o, o}
+	case qctl:
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "" {
+				continue
+			}
+			if err := ui.ctl(line); err != nil {
+				return err
+			}
+		}
+	case qevent:
+		return ui.eventWrite(data)
+	default:
+		return fmt.Errorf("permission denied")
+	}
+	return nil
+}
+
+func (ui *fileUI) ctl(cmd string) error {
+	switch {
+	case cmd == "addr=dot":
+		c0, c1 := ui.body.Cursor().Ordered()
+		ui.addr = span{c0, c1}
+	case cmd == "dot=addr":
+		ui.body.SetCursor(duit.Cursor{Cur: ui.addr.q1, Start: ui.addr.q0})
+		dui.MarkDraw(ui.body)
+	case cmd == "show":
+		ui.body.ScrollCursor(dui)
+		dui.MarkDraw(ui.body)
+	case cmd == "clean":
+		ui.body.Saved()
+		ui.resetHist()
+		dui.MarkDraw(ui)
+	case cmd == "dirty":
+		ui.square.dirty = true
+		dui.MarkDraw(ui.square)
+	case cmd == "cleartag":
+		t := ui.tagText()
+		if i := strings.Index(t, "|"); i >= 0 {
+			ui.replace('F', ui.header, span{int64(i + 1), int64(len(t))}, nil)
+		}
+	case cmd == "del":
+		if ui.modified() && !ui.warned {
+			return fmt.Errorf("file dirty")
+		}
+		ui.del()
+	case cmd == "delete":
+		ui.del()
+	case cmd == "get":
+		ui.get()
+	case cmd == "put":
+		ui.save()
+	case strings.HasPrefix(cmd, "name "):
+		ui.setName(strings.TrimSpace(cmd[len("name "):]))
+	case cmd == "mark" || cmd == "nomark" || cmd == "menu" || cmd == "nomenu" || strings.HasPrefix(cmd, "dump") || strings.HasPrefix(cmd, "limit="):
+		// accepted for compatibility, nothing to do
+	default:
+		return fmt.Errorf("bad control message %q", cmd)
+	}
+	return nil
+}
+
+// replace replaces s in edit, which is the tag or body of ui, and sends events with origin c1 to readers of the event file.
+// Changes to the body are made in the zerox windows too.
+func (ui *fileUI) replace(c1 byte, edit *duit.Edit, s span, buf []byte) {
+	if edit == ui.body && ui.stale() {
+		topUI.error(ui.path(), errStale, "edit")
+		return
+	}
+	ui.replaceText(c1, edit, s, buf)
+	if edit == ui.body {
+		ui.zeroxCopy(s, buf)
+	}
+}
+
+// replaceText is replace for ui only.
+func (ui *fileUI) replaceText(c1 byte, edit *duit.Edit, s span, buf []byte) {
+	if s.q0 == s.q1 && len(buf) == 0 {
+		return
+	}
+	listening := ui.events.listening()
+	var q0, q1 int64
+	if listening {
+		q0 = runeOffset(edit, s.q0)
+		q1 = runeOffset(edit, s.q1)
+	}
+	edit.Replace(duit.Cursor{Cur: s.q1, Start: s.q0}, buf)
+	if edit == ui.body {
+		ui.hist = bodyHist{s.q0, append([]byte{}, buf...), editSize(edit), true}
+		ui.bodyChanged(s, len(buf))
+	}
+	dui.MarkDraw(ui)
+	if !listening {
+		return
+	}
+	if q1 > q0 {
+		ui.sendEvent(c1, ui.eventType(edit, 'D'), q0, q1, 0, "")
+	}
+	if len(buf) > 0 {
+		ui.sendEvent(c1, ui.eventType(edit, 'I'), q0, q0+int64(utf8.RuneCount(buf)), 0, string(buf))
+	}
+}
+
+// eventType returns c2, lower case for events in the tag.
+func (ui *fileUI) eventType(edit *duit.Edit, c2 byte) byte {
+	if edit == ui.header {
+		return c2 - 'A' + 'a'
+	}
+	return c2
+}
+
+func (ui *fileUI) sendEvent(c1, c2 byte, q0, q1 int64, flag int, text string) {
+	nr := utf8.RuneCountInString(text)
+	if nr > 256 {
+		text = ""
+	}
+	ui.events.post(fmt.Sprintf("%c%c%d %d %d %d %s\n", c1, c2, q0, q1, flag, nr, text))
+}
+
+// clickEvent sends a mouse event for a button 2 (c2 'X') or 3 (c2 'L') click at offset in edit to the event file.
+// If nobody is reading events, clickEvent returns false and the click should be handled as usual.
+func (ui *fileUI) clickEvent(c2 byte, edit *duit.Edit, offset int64) bool {
+	if !ui.events.listening() {
+		return false
+	}
+	s := expandRange(edit, offset)
+	text := spanText(edit, s)
+	flag := 0
+	if c2 == 'X' && isBuiltin(text) {
+		flag = 1
+	}
+	ui.sendEvent('M', ui.eventType(edit, c2), runeOffset(edit, s.q0), runeOffset(edit, s.q1), flag, text)
+	return true
+}
+
+// keyEvents sends events for a change made by a key in the body: s in the text before the key was replaced by ins.
+// del is the text of s, if known.
+func (ui *fileUI) keyEvents(s span, del, ins []byte) {
+	if !ui.events.listening() {
+		return
+	}
+	q0 := runeOffset(ui.body, s.q0)
+	if s.q1 > s.q0 {
+		n := s.q1 - s.q0
+		if del != nil {
+			n = int64(utf8.RuneCount(del))
+		}
+		ui.sendEvent('K', 'D', q0, q0+n, 0, "")
+	}
+	if len(ins) > 0 {
+		ui.sendEvent('K', 'I', q0, q0+int64(utf8.RuneCount(ins)), 0, string(ins))
+	}
+}
+
+// tagEvents sends events for the change a key made in the tag, otag is the tag from before the key.
+func (ui *fileUI) tagEvents(otag []byte) {
+	tag, err := ui.header.Text()
+	if err != nil {
+		return
+	}
+	o, del, ins := textDiff(otag, tag)
+	q0 := int64(utf8.RuneCount(otag[:o]))
+	if len(del) > 0 {
+		ui.sendEvent('K', 'd', q0, q0+int64(utf8.RuneCount(del)), 0, "")
+	}
+	if len(ins) > 0 {
+		ui.sendEvent('K', 'i', q0, q0+int64(utf8.RuneCount(ins)), 0, string(ins))
+	}
+}
+
+// eventWrite handles events written back by a client, so acvi executes or looks up the text as usual.
+func (ui *fileUI) eventWrite(data []byte) error {
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if len(line) < 2 {
+			return fmt.Errorf("bad event %q", line)
+		}
+		var q0, q1 int64
+		if _, err := fmt.Sscanf(line[2:], "%d %d", &q0, &q1); err != nil {
+			return fmt.Errorf("bad event %q", line)
+		}
+		edit := ui.body
+		if line[1] >= 'a' && line[1] <= 'z' {
+			edit = ui.header
+		}
+		s := span{byteOffset(edit, q0), byteOffset(edit, q1)}
+		text := spanText(edit, s)
+		switch line[1] {
+		case 'x', 'X':
+			ui.execute(text)
+		case 'l', 'L':
+			ui.look(text)
+		default:
+			return fmt.Errorf("bad event %q", line)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"9fans.net/go/draw"
+	"github.com/mjl-/duit"
+)
+
+// testFsClient sends 9P requests to an in-process file system server.
+type testFsClient struct {
+	t    *testing.T
+	conn net.Conn
+	tag  uint16
+}
+
+func (c *testFsClient) send(f *fcall) {
+	c.tag++
+	f.Tag = c.tag
+	if _, err := c.conn.Write(f.bytes()); err != nil {
+		c.t.Fatalf("write %d: %s", f.Type, err)
+	}
+}
+
+func (c *testFsClient) recv() *fcall {
+	r, err := readFcall(c.conn)
+	if err != nil {
+		c.t.Fatalf("read reply: %s", err)
+	}
+	if r.Type == rerror {
+		c.t.Fatalf("error reply: %s", r.Ename)
+	}
+	return r
+}
+
+func (c *testFsClient) rpc(f *fcall) *fcall {
+	c.send(f)
+	return c.recv()
+}
+
+func (c *testFsClient) open(fid uint32, path ...string) {
+	c.rpc(&fcall{Type: twalk, Fid: 0, Newfid: fid, Wname: path})
+	c.rpc(&fcall{Type: topen, Fid: fid, Mode: ordwr})
+}
+
+func (c *testFsClient) read(fid uint32) string {
+	return string(c.rpc(&fcall{Type: tread, Fid: fid, Count: 8 * 1024}).Data)
+}
+
+func (c *testFsClient) write(fid uint32, s string) {
+	c.rpc(&fcall{Type: twrite, Fid: fid, Data: []byte(s)})
+}
+
+func TestFsys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/file.txt"
+	if err := ioutil.WriteFile(path, []byte("hello world\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	font := &draw.Font{Height: 10}
+	dui = &duit.DUI{Display: &draw.Display{DPI: draw.DefaultDPI, DefaultFont: font}, Call: make(chan func())}
+	otext := textColors
+	textColors = &duit.EditColors{}
+	stop := make(chan struct{})
+	call := dui.Call
+	go func() {
+		for {
+			select {
+			case fn := <-call:
+				fn()
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer func() {
+		close(stop)
+		dui = nil
+		topUI = nil
+		textColors = otext
+	}()
+
+	var ui *fileUI
+	uiCall(func() {
+		col := newColumnUI(nil)
+		topUI = &mainUI{columns: []*columnUI{col}}
+		// without the focus and layout of columnUI.addFile, which need a display
+		ui = newFileUI(col, path)
+		col.files.files = append(col.files.files, ui)
+		dui.Top.UI = ui
+		ui.body.Font = font
+		kid := ui.bodyBox.Kids[0]
+		kid.R = image.Rect(0, 0, 400, 300)
+		ui.body.Layout(dui, kid, kid.R.Size(), true)
+	})
+	id := fmt.Sprintf("%d", ui.id)
+
+	srv, cli := net.Pipe()
+	defer cli.Close()
+	go newFsConn(srv).serve()
+	c := &testFsClient{t: t, conn: cli}
+	c.rpc(&fcall{Type: tversion, Msize: maxMsg, Version: "9P2000"})
+	c.rpc(&fcall{Type: tattach, Fid: 0, Afid: nofid, Uname: "test"})
+
+	c.open(1, id, "body")
+	c.open(2, id, "addr")
+	c.open(3, id, "ctl")
+	c.open(4, id, "event")
+	c.open(5, id, "data")
+
+	if s := c.read(1); s != "hello world\n" {
+		t.Fatalf("body %q", s)
+	}
+
+	// writes on one fid are handled in order, also when sent before the replies
+	c.send(&fcall{Type: twrite, Fid: 1, Data: []byte("a")})
+	c.send(&fcall{Type: twrite, Fid: 1, Data: []byte("b")})
+	c.recv()
+	c.recv()
+	if s := c.read(1); s != "hello world\nab" {
+		t.Fatalf("body after writes %q", s)
+	}
+	if s := c.read(4); s != "EI12 13 0 1 a\nEI13 14 0 1 b\n" {
+		t.Fatalf("events after body writes %q", s)
+	}
+
+	c.write(2, "#6,#11")
+	if s := c.read(2); s != fmt.Sprintf("%11d %11d ", 6, 11) {
+		t.Fatalf("addr %q", s)
+	}
+	c.write(5, "there")
+	if s := c.read(1); s != "hello there\nab" {
+		t.Fatalf("body after data write %q", s)
+	}
+	if s := c.read(4); s != "ED6 11 0 0 \nEI6 11 0 5 there\n" {
+		t.Fatalf("events after data write %q", s)
+	}
+
+	// a typed key sends an event for the change it made, not for the whole body
+	c.write(2, "#0")
+	c.write(3, "dot=addr")
+	uiCall(func() {
+		ui.bodyKey('x')
+		ui.bodyKey(draw.KeyCmd + 'z')
+	})
+	if s := c.read(4); s != "KI0 1 0 1 x\nKD0 1 0 0 \n" {
+		t.Fatalf("events after keys %q", s)
+	}
+
+	c.write(3, "clean")
+	var dirty bool
+	uiCall(func() {
+		dirty = ui.square.dirty
+	})
+	if dirty {
+		t.Fatalf("window dirty after clean")
+	}
+	c.rpc(&fcall{Type: tclunk, Fid: 4})
+}
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"log"
+	"os"
 	"path"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/mjl-/duit"
 )
@@ -23,6 +26,8 @@ func buttonText(edit *duit.Edit) string {
 	return string(t)
 }
 
+// readAtFull reads len(buf) bytes at offset, or up to the end of src.
+// A duit.Edit reader returns io.EOF with the end of each part of the text, not only at the end of the text.
 func readAtFull(src io.ReaderAt, buf []byte, offset int64) (read int, err error) {
 	want := len(buf)
 	for want > 0 {
@@ -34,34 +39,83 @@ func readAtFull(src io.ReaderAt, buf []byte, offset int64) (read int, err error)
 			want -= n
 			buf = buf[n:]
 		}
-		if err == io.EOF {
+		if err != nil && (err != io.EOF || n == 0) {
 			return
 		}
 	}
-	return
+	return read, nil
 }
 
-func expandText(edit *duit.Edit, offset int64) string {
-	c := edit.Cursor()
-	c0, c1 := c.Ordered()
+// expandRange returns the selection if offset is in it, or the non-whitespace text around offset.
+func expandRange(edit *duit.Edit, offset int64) span {
+	c0, c1 := edit.Cursor().Ordered()
 	if c0 != c1 && offset >= c0 && offset <= c1 {
-		buf, err := edit.Selection()
-		topUI.error("", err, "expanded text")
-		return string(buf)
+		return span{c0, c1}
 	}
 	br := edit.ReverseEditReader(offset)
 	fr := edit.EditReader(offset)
 	br.Nonwhitespace()
 	fr.Nonwhitespace()
-	buf := make([]byte, int(fr.Offset()-br.Offset()))
-	n, err := readAtFull(edit.Reader(), buf, br.Offset())
-	if err != nil {
+	return span{br.Offset(), fr.Offset()}
+}
+
+func expandText(edit *duit.Edit, offset int64) string {
+	s := expandRange(edit, offset)
+	return spanText(edit, s)
+}
+
+func spanText(edit *duit.Edit, s span) string {
+	buf := make([]byte, int(s.q1-s.q0))
+	n, err := readAtFull(edit.Reader(), buf, s.q0)
+	if err != nil && err != io.EOF {
 		log.Printf("read: %s\n", err)
 		return ""
 	}
 	return string(buf[:n])
 }
 
+// runeOffset returns the offset in characters of byte offset o in edit.
+func runeOffset(edit *duit.Edit, o int64) int64 {
+	buf := make([]byte, int(o))
+	n, _ := readAtFull(edit.Reader(), buf, 0)
+	return int64(utf8.RuneCount(buf[:n]))
+}
+
+// byteOffset returns the byte offset of character offset q in edit, at most the end of the text.
+func byteOffset(edit *duit.Edit, q int64) int64 {
+	r := edit.EditReader(0)
+	for ; q > 0; q-- {
+		if _, err := r.TryGet(); err != nil {
+			break
+		}
+	}
+	return r.Offset()
+}
+
+// textDiff returns the byte offset at which obuf and nbuf start to differ, and the text removed from obuf and inserted from nbuf at that offset.
+func textDiff(obuf, nbuf []byte) (o int, del, ins []byte) {
+	n := minimum(len(obuf), len(nbuf))
+	for o < n && obuf[o] == nbuf[o] {
+		o++
+	}
+	for o > 0 && o < len(obuf) && !utf8.RuneStart(obuf[o]) {
+		o--
+	}
+	e := 0
+	for e < n-o && obuf[len(obuf)-1-e] == nbuf[len(nbuf)-1-e] {
+		e++
+	}
+	for e > 0 && !utf8.RuneStart(obuf[len(obuf)-e]) {
+		e--
+	}
+	return o, obuf[o : len(obuf)-e], nbuf[o : len(nbuf)-e]
+}
+
+// scratch returns whether filename is a window for output that is not saved, +Errors, +Jobs or a win window.
+func scratch(filename string) bool {
+	return strings.HasSuffix(filename, "/+Errors") || strings.HasSuffix(filename, "/+Jobs") || strings.HasSuffix(filename, "/-win")
+}
+
 func errorDest(s string) string {
 	if !strings.HasSuffix(s, "/+Errors") {
 		if !strings.HasSuffix(s, "/") {
@@ -72,6 +126,37 @@ func errorDest(s string) string {
 	return s
 }
 
+// commandDir returns the directory for running commands from the window with filename: the directory itself for directory windows, otherwise the directory of the file.
+func commandDir(filename string) string {
+	if filename == "" {
+		return ""
+	}
+	if strings.HasSuffix(filename, "/") {
+		return filename
+	}
+	return path.Dir(filename)
+}
+
+// commandEnv returns the environment for commands started from window w, which can be nil.
+// Like acme, $% and $samfile are the file name and $winid the window id. $acmeaddr is the selection as character offsets, and $acvisock the socket for remote open.
+func commandEnv(w *fileUI) []string {
+	env := os.Environ()
+	if remoteListener != nil {
+		env = append(env, "acvisock="+remoteSocket())
+	}
+	if w == nil {
+		return env
+	}
+	p := w.path()
+	c0, c1 := w.body.Cursor().Ordered()
+	return append(env,
+		"%="+p,
+		"samfile="+p,
+		fmt.Sprintf("winid=%d", w.id),
+		fmt.Sprintf("acmeaddr=#%d,#%d", runeOffset(w.body, c0), runeOffset(w.body, c1)),
+	)
+}
+
 func minimum(a, b int) int {
 	if a < b {
 		return a
@@ -153,3 +238,32 @@ func setMinDims(dim []int, min int) {
 		}
 	}
 }
+
+// namespace returns the directory for posting services, like plan9port's getns.
+func namespace() string {
+	if ns := os.Getenv("NAMESPACE"); ns != "" {
+		return ns
+	}
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		display = ":0.0"
+	}
+	display = strings.TrimSuffix(display, ".0")
+	display = strings.Replace(display, "/", "_", -1)
+	user := os.Getenv("USER")
+	if user == "" {
+		user = "none"
+	}
+	return fmt.Sprintf("/tmp/ns.%s.%s", user, display)
+}
+
+// uiCall runs fn from the main loop and waits for it to finish.
+// It must not be called from the main loop itself.
+func uiCall(fn func()) {
+	done := make(chan struct{})
+	dui.Call <- func() {
+		defer close(done)
+		fn()
+	}
+	<-done
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mjl-/duit"
+)
+
+// Autoindent: a new line opened in a body, with enter or vi o, starts with the
+// leading whitespace of the line above it. A line opened with vi O gets that of the line below.
+// The newline and indent are inserted as one change, so a single undo removes both.
+// Like acme, "Indent" toggles a window, "Indent on" and "Indent off" set it, and
+// "Indent ON" and "Indent OFF" set the default for new windows and all open windows.
+
+var autoindent bool // default for new windows, from -a
+
+func (ui *mainUI) indent(win *fileUI, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: Indent [on | off | ON | OFF]")
+	}
+	arg := ""
+	if len(args) == 1 {
+		arg = args[0]
+	}
+	switch arg {
+	case "ON", "OFF":
+		autoindent = arg == "ON"
+		for _, w := range ui.windows() {
+			w.indent = autoindent
+		}
+		return nil
+	case "", "on", "off":
+	default:
+		return fmt.Errorf("usage: Indent [on | off | ON | OFF]")
+	}
+	if win == nil {
+		return fmt.Errorf("only works on windows")
+	}
+	if arg == "" {
+		win.indent = !win.indent
+	} else {
+		win.indent = arg == "on"
+	}
+	return nil
+}
+
+// autoindent opens a new line for key k with the indent of the current line, as a single change.
+// It is called before the body handles k, and returns whether it handled it.
+func (ui *fileUI) autoindent(k rune) bool {
+	if !ui.indent || k != '\n' && k != 'o' && k != 'O' {
+		return false
+	}
+	if k == '\n' && !ui.vi.insert() || k != '\n' && !ui.vi.command() {
+		return false
+	}
+	c0, c1 := ui.body.Cursor().Ordered()
+	br := ui.body.ReverseEditReader(c0)
+	br.Line(false)
+	start := br.Offset()
+	ws, _ := ui.body.EditReader(start).Whitespace(false)
+	if k == '\n' && int64(len(ws)) > c0-start {
+		ws = ws[:c0-start]
+	}
+	if ws == "" {
+		return false
+	}
+
+	var s span     // replaced
+	var buf []byte // inserted
+	var cur int64  // new cursor
+	switch k {
+	case '\n':
+		s = span{c0, c1}
+		buf = []byte("\n" + ws)
+		cur = c0 + int64(len(buf))
+	case 'o':
+		fr := ui.body.EditReader(c0)
+		fr.Line(false)
+		end := fr.Offset()
+		s = span{end, end}
+		buf = []byte("\n" + ws)
+		cur = end + int64(len(buf))
+	case 'O':
+		s = span{start, start}
+		buf = []byte(ws + "\n")
+		cur = start + int64(len(ws))
+	}
+	ui.replace('K', ui.body, s, buf)
+	ui.body.SetCursor(duit.Cursor{Cur: cur, Start: cur})
+	if k != '\n' {
+		// like vi o and O, continue in insert mode
+		ui.bodyKey('i')
+	}
+	ui.body.ScrollCursor(dui)
+	dui.MarkDraw(ui)
+	return true
+}
@@ -0,0 +1,192 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Directory windows are kept up to date with inotify, and files shown in windows are checked when their directory changes.
+// A single inotify instance watches all directories shown in windows, and the directories of files shown in windows.
+var dirWatch struct {
+	fd      int // -1 if inotify could not be initialized
+	mutex   sync.Mutex
+	dirs    map[int32]string // watch descriptor to directory, used by the reader
+	uses    map[int32]int    // watch descriptor to useWindows and useFiles, used by the reader
+	wds     map[string]int32
+	windows map[string]map[*fileUI]struct{} // directory to windows showing it, only accessed from the main loop
+	files   map[string]bool                 // directories of files shown in windows, only accessed from the main loop
+}
+
+const (
+	useWindows = 1 << iota // directory is shown in a window
+	useFiles               // directory has files shown in windows
+)
+
+const dirWatchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+const fileWatchMask = dirWatchMask | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE | syscall.IN_ATTRIB
+
+// initDirWatch starts inotify on first use, and returns whether it is available.
+func initDirWatch() bool {
+	if dirWatch.windows == nil {
+		dirWatch.dirs = map[int32]string{}
+		dirWatch.uses = map[int32]int{}
+		dirWatch.wds = map[string]int32{}
+		dirWatch.windows = map[string]map[*fileUI]struct{}{}
+		dirWatch.files = map[string]bool{}
+		fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC)
+		if err != nil {
+			log.Printf("inotify: init: %s\n", err)
+			fd = -1
+		} else {
+			go readDirEvents(fd)
+		}
+		dirWatch.fd = fd
+	}
+	return dirWatch.fd >= 0
+}
+
+// updateWatch adds, changes or removes the watch for dir, for the windows and files that need it.
+func updateWatch(dir string) error {
+	use := 0
+	if dirWatch.windows[dir] != nil {
+		use |= useWindows
+	}
+	if dirWatch.files[dir] {
+		use |= useFiles
+	}
+	dirWatch.mutex.Lock()
+	defer dirWatch.mutex.Unlock()
+	wd, ok := dirWatch.wds[dir]
+	if use == 0 {
+		if ok {
+			delete(dirWatch.wds, dir)
+			delete(dirWatch.dirs, wd)
+			delete(dirWatch.uses, wd)
+			syscall.InotifyRmWatch(dirWatch.fd, uint32(wd))
+		}
+		return nil
+	}
+	if ok && dirWatch.uses[wd] == use {
+		return nil
+	}
+	mask := uint32(dirWatchMask)
+	if use&useFiles != 0 {
+		mask = fileWatchMask
+	}
+	nwd, err := syscall.InotifyAddWatch(dirWatch.fd, dir, mask)
+	if err != nil {
+		return err
+	}
+	wd = int32(nwd)
+	dirWatch.dirs[wd] = dir
+	dirWatch.uses[wd] = use
+	dirWatch.wds[dir] = wd
+	return nil
+}
+
+// watchDir starts watching dir for changes, refreshing the listing of w.
+func watchDir(w *fileUI, dir string) {
+	if !initDirWatch() {
+		return
+	}
+	m := dirWatch.windows[dir]
+	if m == nil {
+		m = map[*fileUI]struct{}{}
+		dirWatch.windows[dir] = m
+		if err := updateWatch(dir); err != nil {
+			log.Printf("inotify: watch %s: %s\n", dir, err)
+			delete(dirWatch.windows, dir)
+			return
+		}
+	}
+	m[w] = struct{}{}
+}
+
+// unwatchDir stops refreshing w, and watching its directory if no other window shows it.
+func unwatchDir(w *fileUI) {
+	for dir, m := range dirWatch.windows {
+		if _, ok := m[w]; !ok {
+			continue
+		}
+		delete(m, w)
+		if len(m) > 0 {
+			continue
+		}
+		delete(dirWatch.windows, dir)
+		updateWatch(dir)
+	}
+}
+
+// watchFileDirs watches dirs, the directories of files shown in windows, waking watchFiles when they change.
+// It returns false if not all dirs can be watched, the files must then be polled.
+func watchFileDirs(dirs map[string]bool) bool {
+	if !initDirWatch() {
+		return false
+	}
+	ok := true
+	for dir := range dirWatch.files {
+		if !dirs[dir] {
+			delete(dirWatch.files, dir)
+			updateWatch(dir)
+		}
+	}
+	for dir := range dirs {
+		if dirWatch.files[dir] {
+			continue
+		}
+		dirWatch.files[dir] = true
+		if err := updateWatch(dir); err != nil {
+			log.Printf("inotify: watch %s: %s\n", dir, err)
+			delete(dirWatch.files, dir)
+			ok = false
+		}
+	}
+	return ok
+}
+
+func readDirEvents(fd int) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := syscall.Read(fd, buf)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || n <= 0 {
+			log.Printf("inotify: read: %v\n", err)
+			return
+		}
+		changed := map[string]bool{}
+		files := false
+		dirWatch.mutex.Lock()
+		for o := 0; o+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[o]))
+			use := dirWatch.uses[ev.Wd]
+			if dir, ok := dirWatch.dirs[ev.Wd]; ok && use&useWindows != 0 && ev.Mask&dirWatchMask != 0 {
+				changed[dir] = true
+			}
+			if use&useFiles != 0 {
+				files = true
+			}
+			o += syscall.SizeofInotifyEvent + int(ev.Len)
+		}
+		dirWatch.mutex.Unlock()
+		if files {
+			wakeWatch()
+		}
+		if len(changed) == 0 {
+			continue
+		}
+		dui.Call <- func() {
+			for dir := range changed {
+				for w := range dirWatch.windows[dir] {
+					w.refreshDir()
+				}
+			}
+		}
+	}
+}
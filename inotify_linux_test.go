@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatchFileDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if !watchFileDirs(map[string]bool{dir: true}) {
+		t.Skip("inotify not available")
+	}
+	defer watchFileDirs(nil)
+	select {
+	case <-fileWake:
+	default:
+	}
+
+	if err := ioutil.WriteFile(dir+"/file.txt", []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-fileWake:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no wake after writing a file in a watched directory")
+	}
+
+	watchFileDirs(nil)
+	if _, ok := dirWatch.wds[dir]; ok {
+		t.Fatalf("directory still watched")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// Directory windows are only refreshed automatically on linux, with inotify. Elsewhere, files shown in windows are polled.
+
+func watchDir(w *fileUI, dir string) {
+}
+
+func unwatchDir(w *fileUI) {
+}
+
+// watchFileDirs returns false, files shown in windows are polled.
+func watchFileDirs(dirs map[string]bool) bool {
+	return false
+}
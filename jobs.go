@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Commands started from tags are jobs, listed in the +Jobs window and stopped with Kill.
+// Jobs run in their own process group, so Kill also stops the processes they started.
+// Jobs are only changed from the main loop.
+
+type job struct {
+	id    int
+	cmd   string
+	win   *fileUI // window the command was started from, can be nil
+	start time.Time
+	pid   int
+}
+
+var (
+	jobs       []*job
+	lastJobID  int
+	jobsWindow *fileUI
+)
+
+// newJob returns a job for cmd started from window w, for registering once the command runs.
+func newJob(cmd string, w *fileUI) *job {
+	lastJobID++
+	return &job{id: lastJobID, cmd: cmd, win: w}
+}
+
+// started registers the job as running. It is called from outside the main loop.
+func (j *job) started(pid int) {
+	dui.Call <- func() {
+		j.pid = pid
+		j.start = time.Now()
+		jobs = append(jobs, j)
+		updateJobs()
+	}
+}
+
+// finished unregisters the job. It is called from outside the main loop.
+func (j *job) finished() {
+	dui.Call <- func() {
+		for i, jj := range jobs {
+			if jj == j {
+				jobs = append(jobs[:i], jobs[i+1:]...)
+				break
+			}
+		}
+		updateJobs()
+	}
+}
+
+// updateJobs marks the windows with running jobs and updates the +Jobs window.
+func updateJobs() {
+	busy := map[*fileUI]bool{}
+	for _, j := range jobs {
+		if j.win != nil {
+			busy[j.win] = true
+		}
+	}
+	for _, w := range topUI.windows() {
+		if w.square.busy != busy[w] {
+			w.square.busy = busy[w]
+			dui.MarkDraw(w.square)
+		}
+	}
+	if jobsWindow == nil || jobsWindow.deleted {
+		jobsWindow = nil
+		return
+	}
+	var b strings.Builder
+	for _, j := range jobs {
+		dir := ""
+		if j.win != nil {
+			dir = j.win.path()
+		}
+		fmt.Fprintf(&b, "%d\t%s\t%s\t%s\n", j.id, j.start.Format("15:04:05"), j.cmd, dir)
+	}
+	w := jobsWindow
+	w.replace('F', w.body, span{0, editSize(w.body)}, []byte(b.String()))
+	w.body.Saved()
+	w.resetHist()
+}
+
+// showJobs opens the +Jobs window, listing running commands.
+func (ui *mainUI) showJobs() {
+	if jobsWindow == nil || jobsWindow.deleted {
+		wd, _ := os.Getwd()
+		jobsWindow = ui.ensureFile(wd + "/+Jobs")
+	}
+	updateJobs()
+}
+
+// kill kills the jobs with the ids or command names in names, or all jobs if names is empty.
+// Jobs get a SIGTERM, and a SIGKILL if they are still running a few seconds later.
+// Jobs that already exited are skipped.
+func (ui *mainUI) kill(names []string) error {
+	n := 0
+	var errs []string
+	for _, j := range jobs {
+		match := len(names) == 0
+		t := strings.Fields(j.cmd)
+		for _, name := range names {
+			id, err := strconv.Atoi(name)
+			match = match || err == nil && id == j.id || len(t) > 0 && t[0] == name
+		}
+		if !match {
+			continue
+		}
+		n++
+		if err := syscall.Kill(-j.pid, syscall.SIGTERM); err != nil {
+			if err != syscall.ESRCH {
+				errs = append(errs, fmt.Sprintf("kill %q: %s", j.cmd, err))
+			}
+			continue
+		}
+		j := j
+		time.AfterFunc(killDelay, func() {
+			dui.Call <- func() {
+				for _, jj := range jobs {
+					if jj == j {
+						syscall.Kill(-j.pid, syscall.SIGKILL)
+						break
+					}
+				}
+			}
+		})
+	}
+	if n == 0 {
+		return fmt.Errorf("no matching jobs")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// killDelay is how long Kill waits before sending SIGKILL to jobs still running.
+const killDelay = 3 * time.Second
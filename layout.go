@@ -0,0 +1,89 @@
+package main
+
+import (
+	"image"
+	"unicode/utf8"
+
+	"github.com/mjl-/duit"
+)
+
+// Windows and columns are a tag above a body. The tag grows to as many lines
+// as its text needs, the body gets the remaining height.
+
+// tagFit is the number of lines a tag was laid out with, and the width available for its text.
+type tagFit struct {
+	lines, width int
+}
+
+// changed returns whether tag needs a different number of lines than it was laid out with.
+func (t tagFit) changed(tag *duit.Edit) bool {
+	return t.width > 0 && tagLines(tag, t.width) != t.lines
+}
+
+// tagLines returns the number of lines tag needs at width, wrapping text like duit.Edit draws it.
+func tagLines(tag *duit.Edit, width int) int {
+	text, err := tag.Text()
+	if err != nil {
+		return 1
+	}
+	font := dui.Font(tag.Font)
+	lines := 1
+	sdx := 0
+	for len(text) > 0 {
+		c, n := utf8.DecodeRune(text)
+		text = text[n:]
+		if c == '\n' {
+			lines++
+			sdx = 0
+			continue
+		}
+		dx := font.StringWidth(string(c))
+		if sdx+dx < width {
+			sdx += dx
+			continue
+		}
+		lines++
+		sdx = dx
+	}
+	return lines
+}
+
+// layoutTag lays out kids, a tag box and a body, in size with gap between them.
+// The tag box holds a square and the tag edit.
+func layoutTag(dui *duit.DUI, self *duit.Kid, kids []*duit.Kid, tag *duit.Edit, size image.Point, gap int) tagFit {
+	width := size.X - dui.Scale(duit.ScrollbarSize) - dui.ScaleSpace(duit.EditPadding).Dx()
+	fit := tagFit{tagLines(tag, width), width}
+	h := fit.lines*dui.Font(tag.Font).Height + dui.Scale(1)
+	if h > size.Y {
+		h = size.Y
+	}
+	kids[0].UI.Layout(dui, kids[0], image.Pt(size.X, h), true)
+	kids[0].R = image.Rect(0, 0, size.X, h)
+	y := h + gap
+	if y > size.Y {
+		y = size.Y
+	}
+	kids[1].UI.Layout(dui, kids[1], image.Pt(size.X, size.Y-y), true)
+	kids[1].R = image.Rect(0, y, size.X, size.Y)
+	self.R = image.Rect(0, 0, size.X, size.Y)
+	return fit
+}
+
+// fitTags marks windows and columns for layout if their tag needs more or fewer lines.
+// It returns whether anything was marked.
+func (ui *mainUI) fitTags() bool {
+	changed := false
+	for _, col := range ui.columns {
+		if col.tagFit.changed(col.header) {
+			dui.MarkLayout(col)
+			changed = true
+		}
+		for _, f := range col.files.files {
+			if f.tagFit.changed(f.header) {
+				dui.MarkLayout(f)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
@@ -28,11 +28,24 @@ func main() {
 		log.Printf("usage: acvi [flags] [file ...]\n")
 		flag.PrintDefaults()
 	}
+	remote := flag.Bool("r", false, "open files in a running acvi, starting a new acvi only if none is running")
+	wait := flag.Bool("w", false, "with -r, wait until the windows of the files have been closed, e.g. for $EDITOR")
+	loadFile := flag.String("l", "", "load columns and windows from dump file, as written by Dump")
+	fontName := flag.String("f", os.Getenv("font"), "variable-width font, the default for windows")
+	flag.StringVar(&fixedFontName, "F", os.Getenv("fixedfont"), "fixed-width font, for Font")
+	flag.BoolVar(&autoindent, "a", false, "autoindent new lines in windows, see Indent")
 	flag.Parse()
 	args := flag.Args()
+	if *wait && !*remote {
+		log.Fatalf("-w requires -r\n")
+	}
+
+	if *remote && remoteOpen(args, *wait) {
+		return
+	}
 
 	var err error
-	dui, err = duit.NewDUI("acvi", &duit.DUIOpts{FontName: os.Getenv("font")})
+	dui, err = duit.NewDUI("acvi", &duit.DUIOpts{FontName: *fontName})
 	if err != nil {
 		log.Fatalf("new dui: %s\n", err)
 	}
@@ -78,17 +91,46 @@ func main() {
 	dui.Top.UI = topUI
 	dui.Top.ID = "columns"
 	dui.Render()
+	if *loadFile != "" {
+		topUI.error("", topUI.load(*loadFile), "load")
+		dui.Render()
+	}
+	startFsys()
+	startRemote()
+	if remoteListener != nil {
+		go listenPlumber()
+	}
+	go watchFiles()
 
+	var buttons int
 	for {
 		select {
 		case e := <-dui.Inputs:
 			dui.Input(e)
+			// mouse movement without buttons does not change text
+			if (e.Type != duit.InputMouse || e.Mouse.Buttons != 0 || buttons != 0) && topUI.fitTags() {
+				dui.Render()
+			}
+			if e.Type == duit.InputMouse {
+				buttons = e.Mouse.Buttons
+			}
 
 		case err, ok := <-dui.Error:
 			if !ok {
+				cleanup()
 				return
 			}
 			log.Printf("duit: %s\n", err)
 		}
 	}
 }
+
+// cleanup removes the sockets we posted, before exiting.
+func cleanup() {
+	if fsysListener != nil {
+		fsysListener.Close()
+	}
+	if remoteListener != nil {
+		remoteListener.Close()
+	}
+}
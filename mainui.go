@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
 	"image"
 	"io"
@@ -9,9 +10,8 @@ import (
 	"os"
 	"os/exec"
 	"path"
-	"regexp"
-	"strconv"
 	"strings"
+	"syscall"
 
 	"9fans.net/go/draw"
 	"github.com/mjl-/duit"
@@ -63,6 +63,42 @@ func (ui *mainUI) error(filename string, err error, msg string) bool {
 	return true
 }
 
+// clean returns whether files can be closed without losing changes.
+// Otherwise, the modified files are listed in +Errors and marked, so closing them again goes ahead.
+// Zerox windows only count if all their windows are closed.
+func (ui *mainUI) clean(filename string, files []*fileUI) bool {
+	closing := map[*fileUI]bool{}
+	for _, f := range files {
+		closing[f] = true
+	}
+	var l []*fileUI
+	for _, f := range files {
+		if !f.modified() || f.warned {
+			continue
+		}
+		shared := false
+		for _, z := range f.zeroxFiles() {
+			shared = shared || !closing[z]
+		}
+		if !shared {
+			l = append(l, f)
+		}
+	}
+	if filename == "" {
+		wd, _ := os.Getwd()
+		filename = wd + "/"
+	}
+	for _, f := range l {
+		f.warned = true
+		name := f.path()
+		if name == "" {
+			name = "unnamed file"
+		}
+		ui.output(errorDest(filename), []byte(name+" modified\n"))
+	}
+	return len(l) == 0
+}
+
 func (ui *mainUI) ensureFile(filename string) *fileUI {
 	f := ui.findFile(filename)
 	if f == nil {
@@ -86,15 +122,7 @@ func (ui *mainUI) findFile(filename string) *fileUI {
 	return nil
 }
 
-var addressRegexp *regexp.Regexp
-
-func init() {
-	addressRegexp = regexp.MustCompile(`^([0-9]+)(:([0-9]+))?:?$`)
-}
-
 func (ui *mainUI) look(filename, s string, focus bool) (consumed bool) {
-	// todo: try plumber
-
 	log.Printf("look %q %q\n", filename, s)
 
 	p := s
@@ -109,43 +137,17 @@ func (ui *mainUI) look(filename, s string, focus bool) (consumed bool) {
 		if len(t) != 2 {
 			return
 		}
-		addr := t[1]
-		if strings.HasPrefix(addr, "/") {
-			f.body.LastSearch = addr
-			match = f.body.Search(dui, false)
-			return
-		}
-
-		l := addressRegexp.FindStringSubmatch(addr)
-		if l == nil {
-			topUI.error(filename, fmt.Errorf("bad address"), "parsing address")
+		text, err := f.body.Text()
+		if topUI.error(filename, err, "read body") {
 			return
 		}
-		lines, err := strconv.ParseInt(l[1], 10, 64)
-		if topUI.error(filename, err, "parsing linenumber in address") {
+		r, err := lookAddr(text, t[1])
+		if topUI.error(filename, err, "address") {
 			return
 		}
-		fr := f.body.EditReader(0)
-		for ; lines > 1; lines-- {
-			fr.Line(true)
-		}
-		var c duit.Cursor
-		if l[3] != "" {
-			lineoffset, err := strconv.ParseInt(l[3], 10, 64)
-			if topUI.error(filename, err, "parsing line offset in address") {
-				return
-			}
-			for ; lineoffset > 1; lineoffset-- {
-				fr.TryGet()
-			}
-			c = duit.Cursor{Cur: fr.Offset(), Start: fr.Offset()}
-		} else {
-			c.Start = fr.Offset()
-			fr.Line(true)
-			c.Cur = fr.Offset()
-		}
-		f.body.SetCursor(c)
+		f.body.SetCursor(duit.Cursor{Cur: r.q1, Start: r.q0})
 		f.body.ScrollCursor(dui)
+		dui.MarkDraw(f.body)
 		match = true
 		return
 	}
@@ -193,9 +195,27 @@ func (ui *mainUI) execute(filename, cmd string, edit *duit.Edit) {
 		ui.Kids = append(ui.Kids, &duit.Kid{UI: col})
 		dui.MarkLayout(ui)
 	case "Exit":
+		if !ui.clean(filename, ui.windows()) {
+			return
+		}
 		log.Printf("exit\n")
+		pendingSaves.Wait()
+		cleanup()
 		dui.Close()
 		os.Exit(0)
+	case "Putall":
+		for _, w := range ui.windows() {
+			if w.modified() && w.path() != "" {
+				w.save()
+			}
+		}
+	case "Delall":
+		l := ui.windows()
+		if ui.clean(filename, l) {
+			for _, w := range l {
+				w.del()
+			}
+		}
 	case "Open":
 		if edit == nil {
 			topUI.error(filename, fmt.Errorf("needs selection"), "open files")
@@ -212,52 +232,153 @@ func (ui *mainUI) execute(filename, cmd string, edit *duit.Edit) {
 	default:
 		if filename == "" {
 			filename, _ = os.Getwd()
+			filename += "/"
 		}
 		dest := errorDest(filename)
 		cmd = strings.TrimSpace(cmd)
-		if strings.HasPrefix(cmd, "|") {
-			what := strings.Split(cmd[1:], " ")[0]
+		t := strings.Fields(cmd)
+		if len(t) > 0 {
+			switch t[0] {
+			case "Edit":
+				ui.edit(filename, cmd[len("Edit"):], edit)
+				return
+			case "Cd":
+				dir := os.Getenv("HOME")
+				if len(t) > 1 {
+					dir = t[1]
+					if !strings.HasPrefix(dir, "/") {
+						dir = commandDir(filename) + "/" + dir
+					}
+				}
+				ui.error(filename, os.Chdir(path.Clean(dir)), "cd")
+				return
+			case "Next", "Prev":
+				delta := 1
+				if t[0] == "Prev" {
+					delta = -1
+				}
+				ui.error(filename, ui.nextRef(filename, delta), strings.ToLower(t[0]))
+				return
+			case "Font":
+				win := ui.bodyWindow(edit)
+				if win == nil {
+					ui.error(filename, fmt.Errorf("only works on windows"), "font")
+					return
+				}
+				ui.error(filename, win.setFont(t[1:]), "font")
+				return
+			case "Indent":
+				ui.error(filename, ui.indent(ui.bodyWindow(edit), t[1:]), "indent")
+				return
+			case "Win":
+				ui.error(filename, ui.startWin(filename, t[1:]), "win")
+				return
+			case "Jobs":
+				ui.showJobs()
+				return
+			case "Kill":
+				ui.error(filename, ui.kill(t[1:]), "kill")
+				return
+			case "Dump", "Load":
+				file := defaultDumpFile()
+				if len(t) > 1 {
+					file = t[1]
+				}
+				if t[0] == "Dump" {
+					ui.error(filename, ui.dump(file), "dump")
+				} else {
+					ui.error(filename, ui.load(file), "load")
+				}
+				return
+			}
+		}
+		win := ui.bodyWindow(edit)
+		env := commandEnv(win)
+		if cmd != "" && strings.IndexByte("|<>", cmd[0]) >= 0 {
+			// |cmd replaces the selection with the output of cmd with the selection as input.
+			// <cmd replaces the selection with the output of cmd.
+			// >cmd sends the selection to cmd, its output goes to +Errors.
+			op := cmd[0]
+			cmd = strings.TrimSpace(cmd[1:])
+			what := strings.Split(cmd, " ")[0]
 			if edit == nil {
-				topUI.error(filename, fmt.Errorf("only works on files"), "execute filter")
+				topUI.error(filename, fmt.Errorf("only works on files"), "execute "+string(op))
 				return
 			}
 			cc := edit.Cursor()
-			sel, err := edit.Selection()
-			if ui.error(filename, err, "selection") {
-				return
+			var sel []byte
+			if op != '<' {
+				var err error
+				sel, err = edit.Selection()
+				if ui.error(filename, err, "selection") {
+					return
+				}
 			}
+			j := newJob(cmd, win)
 			go func() {
-				c := exec.Command("sh", "-c", cmd[1:])
-				stdin, err := c.StdinPipe()
-				var buf []byte
-				if err == nil {
-					go func() {
-						_, err := stdin.Write([]byte(sel))
-						err2 := stdin.Close()
-						if err == nil {
-							err = err2
-						}
-						if err != nil {
-							dui.Call <- func() {
-								topUI.error(filename, err, "write to stdin of "+what)
+				c := exec.Command("sh", "-c", cmd)
+				c.Dir = commandDir(filename)
+				c.Env = env
+				c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+				var out bytes.Buffer
+				c.Stdout = &out
+				if op == '>' {
+					c.Stderr = &out
+				}
+				var err error
+				if op != '<' {
+					var stdin io.WriteCloser
+					stdin, err = c.StdinPipe()
+					if err == nil {
+						go func() {
+							_, err := stdin.Write([]byte(sel))
+							err2 := stdin.Close()
+							if err == nil {
+								err = err2
 							}
-						}
-					}()
-					buf, err = c.Output()
+							if err != nil {
+								dui.Call <- func() {
+									topUI.error(filename, err, "write to stdin of "+what)
+								}
+							}
+						}()
+					}
 				}
+				if err == nil {
+					err = c.Start()
+				}
+				if err == nil {
+					j.started(c.Process.Pid)
+					err = c.Wait()
+					j.finished()
+				}
+				buf := out.Bytes()
 				dui.Call <- func() {
-					if topUI.error(filename, err, what) {
+					if op == '>' && len(buf) > 0 {
+						topUI.output(dest, buf)
+					}
+					if topUI.error(filename, err, what) || op == '>' {
 						return
 					}
-					edit.Replace(cc, buf)
+					if win != nil {
+						c0, c1 := cc.Ordered()
+						win.replace('F', edit, span{c0, c1}, buf)
+					} else {
+						edit.Replace(cc, buf)
+					}
 					dui.MarkDraw(edit)
 				}
 			}()
 			return
 		}
+		ui.resetRefs(dest)
+		j := newJob(cmd, win)
 		go func() {
 			what := strings.Split(cmd, " ")[0]
 			c := exec.Command("sh", "-c", cmd)
+			c.Dir = commandDir(filename)
+			c.Env = env
+			c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 			p, err := c.StdoutPipe()
 			if err != nil {
 				dui.Call <- func() {
@@ -275,6 +396,7 @@ func (ui *mainUI) execute(filename, cmd string, edit *duit.Edit) {
 				}
 				return
 			}
+			j.started(c.Process.Pid)
 
 			done := make(chan struct{}, 1)
 			for {
@@ -295,6 +417,7 @@ func (ui *mainUI) execute(filename, cmd string, edit *duit.Edit) {
 				<-done
 			}
 			err = c.Wait()
+			j.finished()
 			if err != nil {
 				dui.Call <- func() {
 					ui.error(filename, err, what)
@@ -392,6 +515,37 @@ func (ui *mainUI) mouseColumn(m draw.Mouse) int {
 	return -1
 }
 
+// moveFile moves file to absolute position p, in any column.
+// The file keeps its edit, so its text, undo history and dirty state are kept.
+func (ui *mainUI) moveFile(file *fileUI, p image.Point) {
+	i := ui.mouseColumn(draw.Mouse{Point: p})
+	if i < 0 {
+		return
+	}
+	col := ui.columns[i]
+	y := p.Y - ui.Kids[i].R.Min.Y - col.Kids[1].R.Min.Y
+	if file.column == col {
+		col.files.move(file, y)
+		return
+	}
+	file.column.removeFile(file)
+	file.column = col
+	col.files.insert(file, y)
+}
+
+// moveFileColumn moves file to the column delta positions away.
+func (ui *mainUI) moveFileColumn(file *fileUI, delta int) {
+	i := ui.columnIndex(file.column)
+	n := len(ui.columns)
+	if i < 0 || n == 1 {
+		return
+	}
+	col := ui.columns[(i+delta+n)%n]
+	file.column.removeFile(file)
+	file.column = col
+	col.files.add(file)
+}
+
 func (ui *mainUI) columnIndex(col *columnUI) int {
 	for i, c := range ui.columns {
 		if c == col {
@@ -472,6 +626,17 @@ func (ui *mainUI) Key(dui *duit.DUI, self *duit.Kid, k rune, m draw.Mouse, orig
 		i := (ui.mouseColumn(m) + 1) % len(ui.Kids)
 		p := image.Pt(ui.Kids[i].R.Min.X+ui.Kids[i].R.Dx()/2, m.Y).Add(orig)
 		r.Warp = &p
+	case draw.KeyCmd + '.', draw.KeyCmd + ',':
+		delta := 1
+		if k == draw.KeyCmd+',' {
+			delta = -1
+		}
+		wd, _ := os.Getwd()
+		ui.error(wd+"/", ui.nextRef("", delta), "next")
+	case draw.KeyCmd + 'o':
+		cycleWindows(1)
+	case draw.KeyCmd + 'O':
+		cycleWindows(-1)
 	case draw.KeyCmd + 'i':
 		i := ui.mouseColumn(m)
 		if i < 0 {
@@ -0,0 +1,68 @@
+package main
+
+// Windows are kept in most recently used order. Closing a window focuses the previous one.
+// Cmd-o cycles through the windows from recent to old, cmd-O the other way, like alt-tab:
+// the order only changes when a key is typed or a button pressed in the window cycled to.
+var mru struct {
+	files    []*fileUI
+	cycle    int  // index in files while cycling, 0 otherwise
+	focusing bool // set while cycling focuses a window
+}
+
+// touchWindow makes f the most recently used window.
+func touchWindow(f *fileUI) {
+	if f.deleted || mru.focusing {
+		return
+	}
+	mru.cycle = 0
+	if len(mru.files) > 0 && mru.files[0] == f {
+		return
+	}
+	forgetWindow(f)
+	mru.files = append([]*fileUI{f}, mru.files...)
+}
+
+// forgetWindow removes f from the list.
+func forgetWindow(f *fileUI) {
+	for i, ff := range mru.files {
+		if ff == f {
+			mru.files = append(mru.files[:i], mru.files[i+1:]...)
+			if mru.cycle > i {
+				mru.cycle--
+			}
+			return
+		}
+	}
+}
+
+// closeWindow removes f from the list, focusing the previous window if f was the most recent.
+func closeWindow(f *fileUI) {
+	recent := len(mru.files) > 0 && mru.files[0] == f
+	forgetWindow(f)
+	if recent && len(mru.files) > 0 {
+		dui.Focus(mru.files[0])
+	}
+}
+
+// cycleWindows focuses the window delta steps away in the list, without changing the order.
+// Windows never focused are added at the end.
+func cycleWindows(delta int) {
+	seen := map[*fileUI]bool{}
+	for _, f := range mru.files {
+		seen[f] = true
+	}
+	for _, f := range topUI.windows() {
+		if !seen[f] {
+			mru.files = append(mru.files, f)
+		}
+	}
+	n := len(mru.files)
+	if n < 2 {
+		return
+	}
+	i := ((mru.cycle+delta)%n + n) % n
+	mru.focusing = true
+	dui.Focus(mru.files[i])
+	mru.focusing = false
+	mru.cycle = i
+}
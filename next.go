@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/mjl-/duit"
+)
+
+// Next and Prev walk through file:line[:col] references in the output of the last command in a +Errors window.
+
+var refRegexp = regexp.MustCompile(`([a-zA-Z0-9_.+\-/]+):([0-9]+)(:[0-9]+)?`)
+var refExtRegexp = regexp.MustCompile(`\.[a-zA-Z][a-zA-Z0-9]*$`)
+
+var (
+	errorRefs      = map[string]span{} // +Errors file name to current reference
+	lastErrorsDest string              // +Errors of last command started
+)
+
+// resetRefs starts walking references at the end of the current output in +Errors window dest.
+func (ui *mainUI) resetRefs(dest string) {
+	var o int64
+	if w := ui.findFile(dest); w != nil {
+		o = editSize(w.body)
+	}
+	errorRefs[dest] = span{o, o}
+	lastErrorsDest = dest
+}
+
+// nextRef looks up the next (delta 1) or previous (delta -1) reference in the +Errors for filename, and selects it in +Errors.
+// If filename is empty, the +Errors of the last command is used.
+func (ui *mainUI) nextRef(filename string, delta int) error {
+	dest := lastErrorsDest
+	if filename != "" {
+		dest = errorDest(filename)
+	}
+	if dest == "" {
+		wd, _ := os.Getwd()
+		dest = errorDest(wd + "/")
+	}
+	w := ui.findFile(dest)
+	if w == nil {
+		return fmt.Errorf("no %s", dest)
+	}
+	text, err := w.body.Text()
+	if err != nil {
+		return err
+	}
+	cur := errorRefs[dest]
+	if cur.q1 > int64(len(text)) {
+		cur = span{}
+	}
+	var ref []int
+	for _, m := range findRefs(text, path.Dir(dest)) {
+		if delta > 0 && int64(m[0]) >= cur.q1 {
+			ref = m
+			break
+		}
+		if delta < 0 && int64(m[1]) <= cur.q0 {
+			ref = m
+		}
+	}
+	if ref == nil {
+		return fmt.Errorf("no more references")
+	}
+	r := span{int64(ref[0]), int64(ref[1])}
+	errorRefs[dest] = r
+	w.body.SetCursor(duit.Cursor{Cur: r.q1, Start: r.q0})
+	w.body.ScrollCursor(dui)
+	dui.MarkDraw(w)
+	if !ui.look(dest, string(text[r.q0:r.q1]), true) {
+		return fmt.Errorf("cannot open %s", text[r.q0:r.q1])
+	}
+	return nil
+}
+
+// findRefs returns the matches of refRegexp in text that are file references.
+// Names must look like a path, with a slash or an extension, or be a file in dir.
+// This skips times like 15:04:05 and ports in URLs like http://host:8080.
+func findRefs(text []byte, dir string) (l [][]int) {
+	for _, m := range refRegexp.FindAllSubmatchIndex(text, -1) {
+		name := string(text[m[2]:m[3]])
+		if strings.HasPrefix(name, "//") || m[0] > 0 && text[m[0]-1] == ':' {
+			continue
+		}
+		if !strings.Contains(name, "/") && !refExtRegexp.MatchString(name) {
+			fi, err := os.Stat(dir + "/" + name)
+			if err != nil || fi.IsDir() {
+				continue
+			}
+		}
+		l = append(l, m)
+	}
+	return
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestFindRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir+"/Makefile", nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir+"/sub", 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text string
+		refs []string
+	}{
+		{"main.go:12: undefined: x", []string{"main.go:12"}},
+		{"./main.go:12:5: undefined: x", []string{"./main.go:12:5"}},
+		{"/usr/lib/go/src/fmt/print.go:3", []string{"/usr/lib/go/src/fmt/print.go:3"}},
+		{"src/cmd:3", []string{"src/cmd:3"}},
+		{"Makefile:3: missing separator", []string{"Makefile:3"}},
+		{"2019/05/31 15:04:05 started", nil},
+		{"at 15:04:05 main.go:7 failed", []string{"main.go:7"}},
+		{"listening on http://localhost:8080/", nil},
+		{"dial tcp //example.com:443", nil},
+		{"localhost:8080", nil},
+		{"version 1.12:3", nil},
+		{"sub:3", nil},
+		{"nofile:3", nil},
+		{"a.go:1 b.go:2", []string{"a.go:1", "b.go:2"}},
+	}
+	for _, tc := range tests {
+		var refs []string
+		for _, m := range findRefs([]byte(tc.text), dir) {
+			refs = append(refs, tc.text[m[0]:m[1]])
+		}
+		if !reflect.DeepEqual(refs, tc.refs) {
+			t.Errorf("%q: refs %q, expected %q", tc.text, refs, tc.refs)
+		}
+	}
+}
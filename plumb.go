@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Plumbing rules in the syntax of plumb(6), for button 3 look.
+// Supported are variable assignments, include, and rules with objects src, dst, wdir, type, data, attr, arg and plumb,
+// with verbs is, matches, isfile, isdir, set, add, delete, to, start and client.
+
+var plumbing struct {
+	file  string
+	mtime time.Time
+	rules *plumbRules
+}
+
+// plumbFile returns the file with plumbing rules, $ACVIPLUMBING or $HOME/lib/plumbing.
+func plumbFile() string {
+	if s := os.Getenv("ACVIPLUMBING"); s != "" {
+		return s
+	}
+	return os.Getenv("HOME") + "/lib/plumbing"
+}
+
+// plumbRulesLoad returns the current rules, reading the rules file again if it changed.
+// A missing rules file means no rules.
+func plumbRulesLoad() (*plumbRules, error) {
+	file := plumbFile()
+	fi, err := os.Stat(file)
+	if os.IsNotExist(err) {
+		plumbing.rules = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if plumbing.rules != nil && plumbing.file == file && plumbing.mtime.Equal(fi.ModTime()) {
+		return plumbing.rules, nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := parsePlumbRules(f, file)
+	if err != nil {
+		return nil, err
+	}
+	plumbing.file = file
+	plumbing.mtime = fi.ModTime()
+	plumbing.rules = rules
+	return rules, nil
+}
+
+// plumb sends text s looked at in filename to an external plumber, if running, in the background.
+// Without a plumber, or if it has no rule for s, s is matched against the plumbing rules in the main loop.
+// Messages for port edit are opened with look, other matches with a start command run it.
+// If s is not handled, look is called in the main loop.
+func (ui *mainUI) plumb(filename, s string, look func()) {
+	wdir := commandDir(filename)
+	if wdir == "" {
+		wdir, _ = os.Getwd()
+	}
+	wdir = path.Clean(wdir)
+	msg := plumbMsg{src: "acvi", typ: "text", wdir: wdir, data: s}
+	go func() {
+		if plumbSend(msg) == nil {
+			return
+		}
+		dui.Call <- func() {
+			if !ui.plumbRules(filename, msg) {
+				look()
+			}
+		}
+	}()
+}
+
+// plumbRules matches msg from filename against the plumbing rules, and returns whether a rule handled it.
+func (ui *mainUI) plumbRules(filename string, msg plumbMsg) bool {
+	rules, err := plumbRulesLoad()
+	if ui.error(filename, err, "plumbing rules") || rules == nil {
+		return false
+	}
+	r, err := rules.match(msg)
+	if ui.error(filename, err, "plumb") || r == nil {
+		return false
+	}
+	if r.msg.dst == "edit" {
+		name := r.msg.data
+		if addr := r.msg.attr["addr"]; addr != "" {
+			name += ":" + addr
+		}
+		return ui.look(strings.TrimSuffix(msg.wdir, "/")+"/", name, true)
+	}
+	if r.start != "" {
+		ui.execute(strings.TrimSuffix(msg.wdir, "/")+"/", r.start, nil)
+		return true
+	}
+	return false
+}
+
+type plumbMsg struct {
+	src, dst, wdir, typ string
+	attr                map[string]string
+	data                string
+}
+
+// plumbPart is literal text or, if isVar, a variable name to expand.
+type plumbPart struct {
+	isVar bool
+	s     string
+}
+
+type plumbRule struct {
+	obj, verb string
+	arg       []plumbPart
+	line      int
+}
+
+type plumbRules struct {
+	sets [][]plumbRule
+	vars map[string][]plumbPart
+}
+
+// plumbResult is the outcome of a matching rule set: the modified message, and a command to start, if any.
+type plumbResult struct {
+	msg   plumbMsg
+	start string
+}
+
+// parsePlumbValue parses words, single-quoted strings in which a doubled quote is a quote, and $variables, up to the end of s.
+func parsePlumbValue(s string) ([]plumbPart, error) {
+	var l []plumbPart
+	lit := ""
+	flush := func() {
+		if lit != "" {
+			l = append(l, plumbPart{s: lit})
+			lit = ""
+		}
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\'':
+			i++
+			for {
+				if i >= len(s) {
+					return nil, fmt.Errorf("unterminated quoted string")
+				}
+				if s[i] == '\'' {
+					if i+1 < len(s) && s[i+1] == '\'' {
+						lit += "'"
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				lit += s[i : i+1]
+				i++
+			}
+		case c == '$' && i+1 < len(s) && (s[i+1] == '_' || isAlnum(s[i+1])):
+			flush()
+			e := i + 1
+			for e < len(s) && (s[e] == '_' || isAlnum(s[e])) {
+				e++
+			}
+			l = append(l, plumbPart{true, s[i+1 : e]})
+			i = e
+		default:
+			lit += s[i : i+1]
+			i++
+		}
+	}
+	flush()
+	return l, nil
+}
+
+var plumbAssignRegexp = regexp.MustCompile(`^([a-zA-Z_][a-zA-Z0-9_]*)[ \t]*=[ \t]*(.*)$`)
+
+// parsePlumbRules reads rules from r. Rule sets are separated by empty lines.
+// Include files are looked up relative to the directory of file, and in $PLAN9/plumb.
+func parsePlumbRules(r io.Reader, file string) (*plumbRules, error) {
+	pr := &plumbRules{vars: map[string][]plumbPart{}}
+	err := pr.parse(r, file, 0)
+	if err != nil {
+		return nil, err
+	}
+	return pr, nil
+}
+
+func (pr *plumbRules) parse(r io.Reader, file string, depth int) error {
+	if depth > 10 {
+		return fmt.Errorf("%s: includes nested too deep", file)
+	}
+	var set []plumbRule
+	flush := func() {
+		if len(set) > 0 {
+			pr.sets = append(pr.sets, set)
+			set = nil
+		}
+	}
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" {
+			flush()
+			continue
+		}
+		bad := func(err error) error {
+			return fmt.Errorf("%s:%d: %s", file, lineno, err)
+		}
+		if m := plumbAssignRegexp.FindStringSubmatch(line); m != nil {
+			v, err := parsePlumbValue(m[2])
+			if err != nil {
+				return bad(err)
+			}
+			pr.vars[m[1]] = v
+			continue
+		}
+		t := strings.Fields(line)
+		if t[0] == "include" && len(t) == 2 {
+			flush()
+			if err := pr.include(t[1], file, depth); err != nil {
+				return bad(err)
+			}
+			continue
+		}
+		if len(t) < 2 {
+			return bad(fmt.Errorf("bad rule %q", line))
+		}
+		obj, verb := t[0], t[1]
+		rest := strings.TrimSpace(line[strings.Index(line, verb)+len(verb):])
+		arg, err := parsePlumbValue(rest)
+		if err != nil {
+			return bad(err)
+		}
+		switch obj {
+		case "src", "dst", "wdir", "type", "data", "attr", "arg", "plumb":
+		default:
+			return bad(fmt.Errorf("unknown object %q", obj))
+		}
+		switch verb {
+		case "is", "matches", "isfile", "isdir", "set", "add", "delete", "to", "start", "client":
+		default:
+			return bad(fmt.Errorf("unknown verb %q", verb))
+		}
+		set = append(set, plumbRule{obj, verb, arg, lineno})
+	}
+	flush()
+	return scanner.Err()
+}
+
+func (pr *plumbRules) include(name, file string, depth int) error {
+	var l []string
+	if path.IsAbs(name) {
+		l = []string{name}
+	} else {
+		l = append(l, path.Join(path.Dir(file), name))
+		plan9 := os.Getenv("PLAN9")
+		if plan9 == "" {
+			plan9 = "/usr/local/plan9"
+		}
+		l = append(l, path.Join(plan9, "plumb", name))
+	}
+	for _, p := range l {
+		f, err := os.Open(p)
+		if err != nil {
+			continue
+		}
+		err = pr.parse(f, p, depth+1)
+		f.Close()
+		return err
+	}
+	return fmt.Errorf("include %s: not found", name)
+}
+
+// plumbMatch holds the state while matching a rule set.
+type plumbMatch struct {
+	pr   *plumbRules
+	msg  plumbMsg
+	vars map[string]string // $0-$9, file, dir
+}
+
+func (pm *plumbMatch) expand(parts []plumbPart) string {
+	s := ""
+	for _, p := range parts {
+		if !p.isVar {
+			s += p.s
+			continue
+		}
+		switch p.s {
+		case "src":
+			s += pm.msg.src
+		case "dst":
+			s += pm.msg.dst
+		case "wdir":
+			s += pm.msg.wdir
+		case "type":
+			s += pm.msg.typ
+		case "data":
+			s += pm.msg.data
+		case "attr":
+			s += formatPlumbAttr(pm.msg.attr)
+		default:
+			if v, ok := pm.vars[p.s]; ok {
+				s += v
+			} else if v, ok := pm.pr.vars[p.s]; ok {
+				s += pm.expand(v)
+			} else {
+				s += os.Getenv(p.s)
+			}
+		}
+	}
+	return s
+}
+
+func (pm *plumbMatch) field(obj string) *string {
+	switch obj {
+	case "src":
+		return &pm.msg.src
+	case "dst":
+		return &pm.msg.dst
+	case "wdir":
+		return &pm.msg.wdir
+	case "type":
+		return &pm.msg.typ
+	case "data":
+		return &pm.msg.data
+	}
+	return nil
+}
+
+// match returns the result of the first rule set matching msg.
+func (pr *plumbRules) match(msg plumbMsg) (*plumbResult, error) {
+	for _, set := range pr.sets {
+		r, err := pr.matchSet(set, msg)
+		if err != nil || r != nil {
+			return r, err
+		}
+	}
+	return nil, nil
+}
+
+func (pr *plumbRules) matchSet(set []plumbRule, msg plumbMsg) (*plumbResult, error) {
+	attr := map[string]string{}
+	for k, v := range msg.attr {
+		attr[k] = v
+	}
+	msg.attr = attr
+	pm := &plumbMatch{pr: pr, msg: msg, vars: map[string]string{}}
+	result := &plumbResult{}
+	for _, r := range set {
+		arg := pm.expand(r.arg)
+		var value string
+		if f := pm.field(r.obj); f != nil {
+			value = *f
+		} else if r.obj == "attr" {
+			value = formatPlumbAttr(pm.msg.attr)
+		} else if r.obj == "arg" {
+			value = arg
+		}
+		switch r.verb {
+		case "is":
+			if value != arg {
+				return nil, nil
+			}
+		case "matches":
+			re, err := regexp.Compile("^(?:" + arg + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", r.line, err)
+			}
+			m := re.FindStringSubmatch(value)
+			if m == nil {
+				return nil, nil
+			}
+			for i := 0; i < 10; i++ {
+				v := ""
+				if i < len(m) {
+					v = m[i]
+				}
+				pm.vars[fmt.Sprintf("%d", i)] = v
+			}
+		case "isfile", "isdir":
+			p := arg
+			if !path.IsAbs(p) && pm.msg.wdir != "" {
+				p = path.Join(pm.msg.wdir, p)
+			}
+			fi, err := os.Stat(p)
+			if err != nil || fi.IsDir() != (r.verb == "isdir") {
+				return nil, nil
+			}
+			if r.verb == "isfile" {
+				pm.vars["file"] = p
+			} else {
+				pm.vars["dir"] = p
+			}
+		case "set":
+			if f := pm.field(r.obj); f != nil {
+				*f = arg
+			} else if r.obj == "attr" {
+				pm.msg.attr = parsePlumbAttr(arg)
+			}
+		case "add":
+			for k, v := range parsePlumbAttr(arg) {
+				pm.msg.attr[k] = v
+			}
+		case "delete":
+			delete(pm.msg.attr, arg)
+		case "to":
+			pm.msg.dst = arg
+		case "start", "client":
+			result.start = arg
+		}
+	}
+	result.msg = pm.msg
+	return result, nil
+}
+
+// parsePlumbAttr parses attributes of the form "name=value name='quoted value'".
+func parsePlumbAttr(s string) map[string]string {
+	m := map[string]string{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		i := strings.IndexAny(s, "= \t")
+		if i < 0 || s[i] != '=' {
+			return m
+		}
+		name := s[:i]
+		s = s[i+1:]
+		value := ""
+		if strings.HasPrefix(s, "'") {
+			for s = s[1:]; s != ""; {
+				if s[0] == '\'' {
+					if len(s) > 1 && s[1] == '\'' {
+						value += "'"
+						s = s[2:]
+						continue
+					}
+					s = s[1:]
+					break
+				}
+				value += s[:1]
+				s = s[1:]
+			}
+		} else {
+			e := strings.IndexAny(s, " \t")
+			if e < 0 {
+				e = len(s)
+			}
+			value = s[:e]
+			s = s[e:]
+		}
+		m[name] = value
+	}
+	return m
+}
+
+func formatPlumbAttr(m map[string]string) string {
+	var l []string
+	for k, v := range m {
+		if v == "" || strings.ContainsAny(v, " \t'=") {
+			v = "'" + strings.Replace(v, "'", "''", -1) + "'"
+		}
+		l = append(l, k+"="+v)
+	}
+	sort.Strings(l)
+	return strings.Join(l, " ")
+}
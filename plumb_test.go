@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPlumbRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(dir+"/main.go", nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir+"/sub", 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	const rules = `
+# urls are started with the browser
+browser = 'web'
+type is text
+data matches 'https?://[^ ]+'
+plumb to web
+plumb start $browser $0
+
+# file:line, relative to wdir
+type is text
+data matches '([a-zA-Z0-9_./-]+):([0-9]+)'
+arg isfile $1
+data set $file
+attr add addr=$2
+plumb to edit
+
+# directories
+type is text
+data matches '[a-zA-Z0-9_./-]+'
+arg isdir $0
+data set $dir
+plumb to edit
+
+# exact text, attributes
+type is text
+data is 'hello world'
+attr add 'greeting=yes' 'quote=it''s'
+attr delete unused
+plumb to greet
+plumb client greeter
+`
+	tests := []struct {
+		data  string
+		dst   string // empty if no rule matches
+		rdata string
+		attr  string
+		start string
+	}{
+		{"https://example.org/x", "web", "https://example.org/x", "unused=x", "web https://example.org/x"},
+		{"main.go:12", "edit", dir + "/main.go", "addr=12 unused=x", ""},
+		{"missing.go:12", "", "", "", ""},
+		{"sub", "edit", dir + "/sub", "unused=x", ""},
+		{"main.go", "", "", "", ""},
+		{"hello world", "greet", "hello world", "greeting=yes quote='it''s'", "greeter"},
+		{"hello", "", "", "", ""},
+	}
+
+	pr, err := parsePlumbRules(strings.NewReader(rules), dir+"/plumbing")
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+	for _, tc := range tests {
+		msg := plumbMsg{src: "acvi", typ: "text", wdir: dir, data: tc.data, attr: map[string]string{"unused": "x"}}
+		r, err := pr.match(msg)
+		if err != nil {
+			t.Errorf("%q: %s", tc.data, err)
+			continue
+		}
+		if r == nil {
+			if tc.dst != "" {
+				t.Errorf("%q: no match, expected dst %q", tc.data, tc.dst)
+			}
+			continue
+		}
+		if tc.dst == "" {
+			t.Errorf("%q: matched with dst %q, expected no match", tc.data, r.msg.dst)
+			continue
+		}
+		attr := formatPlumbAttr(r.msg.attr)
+		if r.msg.dst != tc.dst || r.msg.data != tc.rdata || attr != tc.attr || r.start != tc.start {
+			t.Errorf("%q: got dst %q, data %q, attr %q, start %q, expected %q, %q, %q, %q", tc.data, r.msg.dst, r.msg.data, attr, r.start, tc.dst, tc.rdata, tc.attr, tc.start)
+		}
+	}
+}
+
+func TestPlumbParseErrors(t *testing.T) {
+	tests := []string{
+		"data frobs x",
+		"type is 'unterminated",
+		"include /nonexistent/plumbing",
+	}
+	for _, s := range tests {
+		if _, err := parsePlumbRules(strings.NewReader(s+"\n"), "/tmp/plumbing"); err == nil {
+			t.Errorf("%q: expected error", s)
+		}
+	}
+}
+
+func TestPlumbAttr(t *testing.T) {
+	tests := []struct {
+		s    string
+		attr map[string]string
+	}{
+		{"", map[string]string{}},
+		{"addr=12", map[string]string{"addr": "12"}},
+		{"a=1 b='x y' c='it''s'", map[string]string{"a": "1", "b": "x y", "c": "it's"}},
+	}
+	for _, tc := range tests {
+		attr := parsePlumbAttr(tc.s)
+		if len(attr) != len(tc.attr) {
+			t.Errorf("%q: got %v, expected %v", tc.s, attr, tc.attr)
+			continue
+		}
+		for k, v := range tc.attr {
+			if attr[k] != v {
+				t.Errorf("%q: %s=%q, expected %q", tc.s, k, attr[k], v)
+			}
+		}
+		if s := formatPlumbAttr(attr); tc.s != "" && s != tc.s {
+			t.Errorf("format %v: got %q, expected %q", attr, s, tc.s)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An external plumber, like plan9port's, serves a 9P file system on $NAMESPACE/plumb.
+// Button 3 text is written to its file send, and the first acvi reads messages from port edit.
+// A plumb message is src, dst, wdir, type, attr and the size of data on separate lines, followed by data.
+
+func plumberSocket() string {
+	return namespace() + "/plumb"
+}
+
+// plumbError is an error message from the plumber. The connection can still be used.
+type plumbError string
+
+func (e plumbError) Error() string {
+	return string(e)
+}
+
+// plumbClient is a minimal 9P client with one request outstanding at a time.
+type plumbClient struct {
+	conn    net.Conn
+	nextFid uint32
+}
+
+func dialPlumber() (*plumbClient, error) {
+	conn, err := net.Dial("unix", plumberSocket())
+	if err != nil {
+		return nil, err
+	}
+	c := &plumbClient{conn: conn, nextFid: 1}
+	_, err = c.rpc(&fcall{Type: tversion, Tag: notag, Msize: maxMsg, Version: "9P2000"})
+	if err == nil {
+		_, err = c.rpc(&fcall{Type: tattach, Fid: 0, Afid: nofid, Uname: os.Getenv("USER")})
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *plumbClient) close() {
+	c.conn.Close()
+}
+
+func (c *plumbClient) rpc(f *fcall) (*fcall, error) {
+	if f.Type != tversion {
+		f.Tag = 1
+	}
+	if _, err := c.conn.Write(f.bytes()); err != nil {
+		return nil, err
+	}
+	r, err := readFcall(c.conn)
+	if err != nil {
+		return nil, err
+	}
+	if r.Type == rerror {
+		return nil, plumbError(r.Ename)
+	}
+	if r.Type != f.Type+1 || r.Tag != f.Tag {
+		return nil, fmt.Errorf("unexpected 9P message type %d", r.Type)
+	}
+	return r, nil
+}
+
+// open walks to name in the root and opens it, returning the fid.
+func (c *plumbClient) open(name string, mode uint8) (uint32, error) {
+	fid := c.nextFid
+	c.nextFid++
+	r, err := c.rpc(&fcall{Type: twalk, Fid: 0, Newfid: fid, Wname: []string{name}})
+	if err != nil {
+		return 0, err
+	}
+	if len(r.Wqid) != 1 {
+		return 0, fmt.Errorf("%s: not found", name)
+	}
+	_, err = c.rpc(&fcall{Type: topen, Fid: fid, Mode: mode})
+	return fid, err
+}
+
+// pack returns the wire representation of m.
+func (m plumbMsg) pack() []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s\n%s\n%s\n%s\n%s\n%d\n", m.src, m.dst, m.wdir, m.typ, formatPlumbAttr(m.attr), len(m.data))
+	b.WriteString(m.data)
+	return b.Bytes()
+}
+
+// unpackPlumbMsg parses a message at the start of buf.
+// It returns the number of bytes used, or 0 if buf does not hold a whole message yet.
+func unpackPlumbMsg(buf []byte) (plumbMsg, int, error) {
+	var l []string
+	o := 0
+	for len(l) < 6 {
+		i := bytes.IndexByte(buf[o:], '\n')
+		if i < 0 {
+			return plumbMsg{}, 0, nil
+		}
+		l = append(l, string(buf[o:o+i]))
+		o += i + 1
+	}
+	n, err := strconv.Atoi(l[5])
+	if err != nil || n < 0 {
+		return plumbMsg{}, 0, fmt.Errorf("bad data size %q", l[5])
+	}
+	if len(buf)-o < n {
+		return plumbMsg{}, 0, nil
+	}
+	m := plumbMsg{src: l[0], dst: l[1], wdir: l[2], typ: l[3], attr: parsePlumbAttr(l[4]), data: string(buf[o : o+n])}
+	return m, o + n, nil
+}
+
+// plumbSender is the connection for writing to the send file of the plumber, kept open between messages.
+// Messages are sent outside the main loop, one at a time.
+var plumbSender struct {
+	sync.Mutex
+	c   *plumbClient
+	fid uint32
+}
+
+// plumbSend writes m to the send file of the plumber.
+// It fails if no plumber is running, or if the plumber has no rule for m.
+func plumbSend(m plumbMsg) error {
+	plumbSender.Lock()
+	defer plumbSender.Unlock()
+	reused := plumbSender.c != nil
+	err := plumbWrite(m)
+	if _, ok := err.(plumbError); err == nil || ok {
+		return err
+	}
+	plumbSenderClose()
+	if !reused {
+		return err
+	}
+	// the plumber may have been restarted since the last message
+	err = plumbWrite(m)
+	if _, ok := err.(plumbError); err != nil && !ok {
+		plumbSenderClose()
+	}
+	return err
+}
+
+func plumbWrite(m plumbMsg) error {
+	s := &plumbSender
+	if s.c == nil {
+		c, err := dialPlumber()
+		if err != nil {
+			return err
+		}
+		fid, err := c.open("send", owrite)
+		if err != nil {
+			c.close()
+			return err
+		}
+		s.c = c
+		s.fid = fid
+	}
+	s.c.conn.SetDeadline(time.Now().Add(2 * time.Second))
+	buf := m.pack()
+	for o := 0; o < len(buf); {
+		n := len(buf) - o
+		if n > maxMsg-iohdr {
+			n = maxMsg - iohdr
+		}
+		r, err := s.c.rpc(&fcall{Type: twrite, Fid: s.fid, Offset: uint64(o), Data: buf[o : o+n]})
+		if err != nil {
+			return err
+		}
+		o += int(r.Count)
+	}
+	return nil
+}
+
+func plumbSenderClose() {
+	if plumbSender.c != nil {
+		plumbSender.c.close()
+		plumbSender.c = nil
+	}
+}
+
+// listenPlumber reads messages for port edit from the plumber and opens them, reconnecting while acvi runs.
+func listenPlumber() {
+	open := func(name string) {
+		uiCall(func() {
+			_, err := topUI.openRemote(name)
+			topUI.error("", err, "plumb")
+		})
+	}
+	for {
+		err := readPlumbEdit(open)
+		if err != nil {
+			log.Printf("plumber: %s\n", err)
+		}
+		time.Sleep(5 * time.Second)
+	}
+}
+
+// readPlumbEdit reads messages from port edit of the plumber, and calls open with the file and address of each.
+// It returns when the connection fails, nil if no plumber is running.
+func readPlumbEdit(open func(name string)) error {
+	c, err := dialPlumber()
+	if err != nil {
+		return nil
+	}
+	defer c.close()
+	fid, err := c.open("edit", oread)
+	if err != nil {
+		return err
+	}
+	log.Printf("plumber: reading port edit\n")
+	var buf []byte
+	for {
+		r, err := c.rpc(&fcall{Type: tread, Fid: fid, Count: maxMsg - iohdr})
+		if err != nil {
+			return err
+		}
+		if len(r.Data) == 0 {
+			return fmt.Errorf("eof on port edit")
+		}
+		buf = append(buf, r.Data...)
+		for {
+			m, n, err := unpackPlumbMsg(buf)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			buf = buf[n:]
+			open(plumbEditName(m))
+		}
+	}
+}
+
+// plumbEditName returns the file and address to open for message m from port edit.
+func plumbEditName(m plumbMsg) string {
+	name := m.data
+	if !path.IsAbs(name) && m.wdir != "" {
+		name = path.Join(m.wdir, name)
+	}
+	if addr := m.attr["addr"]; addr != "" {
+		name += ":" + addr
+	}
+	return name
+}
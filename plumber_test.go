@@ -0,0 +1,295 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mjl-/duit"
+)
+
+// testPlumber is a stand-in plumber serving send and edit over 9P on $NAMESPACE/plumb.
+// Messages with data "norule" are refused like a plumber without a matching rule.
+type testPlumber struct {
+	l    net.Listener
+	edit chan []byte // returned by reads from edit
+
+	sync.Mutex
+	conns []net.Conn
+	dials int
+	msgs  []plumbMsg
+}
+
+func startTestPlumber(t *testing.T, dir string) *testPlumber {
+	l, err := net.Listen("unix", dir+"/plumb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPlumber{l: l, edit: make(chan []byte, 1)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			p.Lock()
+			p.conns = append(p.conns, conn)
+			p.dials++
+			p.Unlock()
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+// closeConns drops all connections, like a plumber that restarted.
+func (p *testPlumber) closeConns() {
+	p.Lock()
+	defer p.Unlock()
+	for _, c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+func (p *testPlumber) close() {
+	p.l.Close()
+	p.closeConns()
+}
+
+func (p *testPlumber) serve(conn net.Conn) {
+	defer conn.Close()
+	var buf []byte // partial message written to send
+	for {
+		f, err := readFcall(conn)
+		if err != nil {
+			return
+		}
+		r := &fcall{Type: f.Type + 1, Tag: f.Tag}
+		switch f.Type {
+		case tversion:
+			r.Msize = f.Msize
+			r.Version = "9P2000"
+		case tattach:
+			r.Qid = qid{Type: qtdir}
+		case twalk:
+			r.Wqid = []qid{{Path: 1}}
+		case topen:
+		case twrite:
+			r.Count = uint32(len(f.Data))
+			buf = append(buf, f.Data...)
+			m, n, err := unpackPlumbMsg(buf)
+			if err != nil {
+				return
+			}
+			if n > 0 {
+				buf = buf[n:]
+				if m.data == "norule" {
+					r = &fcall{Type: rerror, Tag: f.Tag, Ename: "no matching plumb rule"}
+				} else {
+					p.Lock()
+					p.msgs = append(p.msgs, m)
+					p.Unlock()
+				}
+			}
+		case tread:
+			r.Data = <-p.edit
+		default:
+			r = &fcall{Type: rerror, Tag: f.Tag, Ename: "not supported"}
+		}
+		if _, err := conn.Write(r.bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func testNamespace(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ons, had := os.LookupEnv("NAMESPACE")
+	os.Setenv("NAMESPACE", dir)
+	return dir, func() {
+		plumbSenderClose()
+		if had {
+			os.Setenv("NAMESPACE", ons)
+		} else {
+			os.Unsetenv("NAMESPACE")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPlumbSend(t *testing.T) {
+	dir, cleanup := testNamespace(t)
+	defer cleanup()
+
+	if err := plumbSend(plumbMsg{src: "acvi", typ: "text", data: "x"}); err == nil {
+		t.Fatalf("send without plumber succeeded")
+	}
+	if plumbSender.c != nil {
+		t.Fatalf("connection kept without plumber")
+	}
+
+	p := startTestPlumber(t, dir)
+	defer p.close()
+
+	msgs := []plumbMsg{
+		{src: "acvi", typ: "text", wdir: "/tmp", attr: map[string]string{}, data: "main.go:12"},
+		{src: "acvi", dst: "edit", typ: "text", wdir: "/tmp", attr: map[string]string{"addr": "3", "x": "a b"}, data: strings.Repeat("long ", 4000)},
+	}
+	for _, m := range msgs {
+		if err := plumbSend(m); err != nil {
+			t.Fatalf("send: %s", err)
+		}
+	}
+	err := plumbSend(plumbMsg{src: "acvi", typ: "text", data: "norule"})
+	if _, ok := err.(plumbError); !ok {
+		t.Fatalf("send without rule: got %v, expected plumb error", err)
+	}
+	p.Lock()
+	if !reflect.DeepEqual(p.msgs, msgs) {
+		t.Errorf("plumber received %v, expected %v", p.msgs, msgs)
+	}
+	if p.dials != 1 {
+		t.Errorf("%d connections, expected 1", p.dials)
+	}
+	p.Unlock()
+
+	// after a restart of the plumber, we connect again
+	p.closeConns()
+	if err := plumbSend(msgs[0]); err != nil {
+		t.Fatalf("send after restart: %s", err)
+	}
+	p.Lock()
+	if p.dials != 2 || len(p.msgs) != 3 {
+		t.Errorf("after restart: %d connections, %d messages, expected 2 and 3", p.dials, len(p.msgs))
+	}
+	p.Unlock()
+}
+
+func TestPlumbEdit(t *testing.T) {
+	dir, cleanup := testNamespace(t)
+	defer cleanup()
+	p := startTestPlumber(t, dir)
+	defer p.close()
+
+	m0 := plumbMsg{src: "plumb", dst: "edit", wdir: "/src", typ: "text", attr: map[string]string{"addr": "12"}, data: "main.go"}
+	m1 := plumbMsg{src: "plumb", dst: "edit", wdir: "/src", typ: "text", attr: map[string]string{}, data: "/etc/hosts"}
+	buf := append(m0.pack(), m1.pack()...)
+
+	names := make(chan string, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- readPlumbEdit(func(name string) {
+			names <- name
+		})
+	}()
+	// the messages arrive in two reads, split inside the first
+	p.edit <- buf[:10]
+	p.edit <- buf[10:]
+	var l []string
+	for len(l) < 2 {
+		select {
+		case name := <-names:
+			l = append(l, name)
+		case err := <-done:
+			t.Fatalf("read port edit: %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout, got %v", l)
+		}
+	}
+	if exp := []string{"/src/main.go:12", "/etc/hosts"}; !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %v, expected %v", l, exp)
+	}
+
+	// a plumber that goes away ends the read
+	p.close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("no error after plumber went away")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("read port edit still running after plumber went away")
+	}
+}
+
+func TestPlumbLook(t *testing.T) {
+	dir, cleanup := testNamespace(t)
+	defer cleanup()
+	orules, had := os.LookupEnv("ACVIPLUMBING")
+	os.Setenv("ACVIPLUMBING", dir+"/norules")
+	defer func() {
+		if had {
+			os.Setenv("ACVIPLUMBING", orules)
+		} else {
+			os.Unsetenv("ACVIPLUMBING")
+		}
+	}()
+	dui = &duit.DUI{Call: make(chan func(), 1)}
+	defer func() {
+		dui = nil
+	}()
+	ui := &mainUI{}
+
+	// without plumber, look is called from the main loop
+	looked := false
+	ui.plumb("", "x", func() {
+		looked = true
+	})
+	select {
+	case fn := <-dui.Call:
+		fn()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no call to the main loop without plumber")
+	}
+	if !looked {
+		t.Fatalf("look not called without plumber")
+	}
+
+	// the plumber takes the message, look is not called
+	p := startTestPlumber(t, dir)
+	defer p.close()
+	ui.plumb("", "x", func() {
+		t.Errorf("look called after the plumber took the message")
+	})
+	for i := 0; ; i++ {
+		p.Lock()
+		n := len(p.msgs)
+		p.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 500 {
+			t.Fatalf("plumber did not get the message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case fn := <-dui.Call:
+		fn()
+	default:
+	}
+
+	// a message without rule in the plumber falls back to look
+	looked = false
+	ui.plumb("", "norule", func() {
+		looked = true
+	})
+	select {
+	case fn := <-dui.Call:
+		fn()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no call to the main loop after the plumber refused")
+	}
+	if !looked {
+		t.Fatalf("look not called after the plumber refused")
+	}
+}
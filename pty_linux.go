@@ -0,0 +1,62 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func ioctl(fd, req uintptr, arg unsafe.Pointer) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// openPty returns a new pseudo-terminal, with echo and newline translation turned off:
+// the text sent is already in the window.
+func openPty() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			master.Close()
+		}
+	}()
+	var unlock int32
+	if err = ioctl(master.Fd(), syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		return nil, nil, fmt.Errorf("unlock pty: %s", err)
+	}
+	var n uint32
+	if err = ioctl(master.Fd(), syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		return nil, nil, fmt.Errorf("pty number: %s", err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var t syscall.Termios
+	err = ioctl(slave.Fd(), syscall.TCGETS, unsafe.Pointer(&t))
+	if err == nil {
+		t.Lflag &^= syscall.ECHO
+		t.Oflag &^= syscall.ONLCR
+		err = ioctl(slave.Fd(), syscall.TCSETS, unsafe.Pointer(&t))
+	}
+	if err != nil {
+		slave.Close()
+		return nil, nil, fmt.Errorf("set pty mode: %s", err)
+	}
+	return master, slave, nil
+}
+
+// processDir returns the current directory of process pid.
+func processDir(pid int) (string, error) {
+	return os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Win windows need a pseudo-terminal, only implemented on linux.
+
+func openPty() (master, slave *os.File, err error) {
+	return nil, nil, fmt.Errorf("pseudo-terminals not supported on this system")
+}
+
+func processDir(pid int) (string, error) {
+	return "", fmt.Errorf("not supported on this system")
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path"
+	"strings"
+)
+
+// Remote open: the first acvi listens on $NAMESPACE/acvi, later "acvi -r" invocations ask it to open files.
+// The protocol is line-based. A client sends "open name" or "edit name", where name can have an address.
+// The server responds with "ok id" or "error message". For "edit", the server sends "closed id" when the window is closed.
+// Closed lines can arrive before the response to a later request, clients tell them apart by their first word.
+
+var remoteListener net.Listener
+
+func remoteSocket() string {
+	return namespace() + "/acvi"
+}
+
+// startRemote listens for remote open requests, unless another acvi is already listening.
+func startRemote() {
+	addr := remoteSocket()
+	os.MkdirAll(path.Dir(addr), 0700)
+	if c, err := net.Dial("unix", addr); err == nil {
+		c.Close()
+		log.Printf("remote: %s already in use, not listening for remote open\n", addr)
+		return
+	}
+	os.Remove(addr)
+	l, err := net.Listen("unix", addr)
+	if err != nil {
+		log.Printf("remote: listen: %s\n", err)
+		return
+	}
+	remoteListener = l
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveRemote(c)
+		}
+	}()
+}
+
+func serveRemote(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		t := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 2)
+		if len(t) != 2 || (t[0] != "open" && t[0] != "edit") {
+			fmt.Fprintf(c, "error bad request\n")
+			continue
+		}
+		var f *fileUI
+		var closed chan struct{}
+		uiCall(func() {
+			f, err = topUI.openRemote(t[1])
+			if err == nil && t[0] == "edit" {
+				closed = make(chan struct{})
+				f.waiters = append(f.waiters, closed)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(c, "error %s\n", err)
+			continue
+		}
+		fmt.Fprintf(c, "ok %d\n", f.id)
+		if closed != nil {
+			go func() {
+				<-closed
+				fmt.Fprintf(c, "closed %d\n", f.id)
+			}()
+		}
+	}
+}
+
+// openRemote opens name, possibly with an address, and focuses its window.
+// Files that do not exist yet are opened in an empty window.
+func (ui *mainUI) openRemote(name string) (*fileUI, error) {
+	p := path.Clean(strings.SplitN(name, ":", 2)[0])
+	if !ui.look("", name, true) {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			return nil, fmt.Errorf("cannot open %s", name)
+		}
+		ui.columns[0].addFile(p)
+	}
+	f := ui.findFile(p)
+	if f == nil {
+		f = ui.findFile(p + "/")
+	}
+	if f == nil {
+		return nil, fmt.Errorf("no window for %s", name)
+	}
+	return f, nil
+}
+
+// remoteOpen asks a running acvi to open files. If wait is set, it returns after all windows have been closed.
+// If no acvi is running, remoteOpen returns false.
+func remoteOpen(names []string, wait bool) bool {
+	c, err := net.Dial("unix", remoteSocket())
+	if err != nil {
+		return false
+	}
+	defer c.Close()
+
+	wd, _ := os.Getwd()
+	cmd := "open"
+	if wait {
+		cmd = "edit"
+	}
+	r := bufio.NewReader(c)
+	pending := 0
+	for _, name := range names {
+		if !strings.HasPrefix(name, "/") {
+			name = wd + "/" + name
+		}
+		fmt.Fprintf(c, "%s %s\n", cmd, name)
+		var line string
+		for {
+			line, err = r.ReadString('\n')
+			if err != nil {
+				log.Fatalf("remote open: %s\n", err)
+			}
+			if !strings.HasPrefix(line, "closed ") {
+				break
+			}
+			// window of an earlier name was closed already
+			pending--
+		}
+		if strings.HasPrefix(line, "error ") {
+			log.Printf("remote open %s: %s", name, line[len("error "):])
+			continue
+		}
+		if !strings.HasPrefix(line, "ok ") {
+			log.Fatalf("remote open: unexpected response %q\n", line)
+		}
+		pending++
+	}
+	if !wait {
+		return true
+	}
+	for pending > 0 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// acvi exited, its windows are closed too
+			break
+		}
+		if strings.HasPrefix(line, "closed ") {
+			pending--
+		}
+	}
+	return true
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRemoteOpenClosed(t *testing.T) {
+	_, cleanup := testNamespace(t)
+	defer cleanup()
+
+	l, err := net.Listen("unix", remoteSocket())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// the window of the first file is closed before the response for the second file is sent
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for id := 1; id <= 2; id++ {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			if id == 2 {
+				fmt.Fprintf(c, "closed 1\n")
+			}
+			fmt.Fprintf(c, "ok %d\n", id)
+		}
+		fmt.Fprintf(c, "closed 2\n")
+		// keep the connection open, remoteOpen must return after the closed lines
+		r.ReadString('\n')
+	}()
+
+	done := make(chan bool)
+	go func() {
+		done <- remoteOpen([]string{"/a", "/b"}, true)
+	}()
+	select {
+	case ok := <-done:
+		if !ok {
+			t.Fatalf("remote open failed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("remote open still waiting after windows were closed")
+	}
+}
@@ -0,0 +1,637 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/mjl-/duit"
+)
+
+// Sam command language, for the Edit builtin.
+// Changes are collected while a command runs, and applied to the text when it finishes.
+// When all commands have run, the changes to each window are applied with a single replace, so they can be undone in one step.
+// Commands with |, <, > or ! run outside the main loop, as a job, and their result is applied when they finish.
+
+type samCmd struct {
+	addr   *addr
+	c      byte      // command, 0 for just an address
+	re     string    // for s, x, y, g, v, X, Y
+	lines  bool      // for x and y without regexp, meaning each line
+	text   string    // for a, c, i, replacement for s, shell command for |, <, >, !
+	n      int       // for s, number of the match to substitute
+	global bool      // for s, substitute all matches
+	mtaddr *addr     // for m and t
+	cmd    *samCmd   // for x, y, g, v, X, Y
+	cmds   []*samCmd // for {}
+}
+
+// samFile holds the state of a window while sam commands run.
+type samFile struct {
+	win     *fileUI
+	path    string
+	orig    []byte // text before the Edit
+	text    []byte // text after the finished commands
+	dot     span
+	changes []samChange // for the current command
+}
+
+type samChange struct {
+	s   span
+	buf []byte
+}
+
+type samExec struct {
+	files []*samFile
+	out   bytes.Buffer // for p, =, f, > and !
+	nest  int
+	async bool // running outside the main loop
+	last  lastRegexp
+}
+
+// parseSam parses the commands in s, separated by newlines.
+func parseSam(s string) ([]*samCmd, error) {
+	p := &parser{s: s}
+	var l []*samCmd
+	for {
+		p.skipWhitespace()
+		if p.pos == len(p.s) {
+			return l, nil
+		}
+		cmd, err := p.samCmd()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, cmd)
+	}
+}
+
+func (p *parser) skipWhitespace() {
+	for p.pos < len(p.s) && strings.IndexByte(" \t\n", p.s[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+func (p *parser) samCmd() (*samCmd, error) {
+	a, err := p.compoundAddr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	cmd := &samCmd{addr: a, c: p.get()}
+	switch cmd.c {
+	case 0, '\n':
+		if a == nil {
+			return nil, fmt.Errorf("missing command")
+		}
+		cmd.c = 0
+		return cmd, nil
+
+	case 'a', 'c', 'i':
+		cmd.text = p.samText()
+
+	case 'd', 'p', 'f':
+
+	case '=':
+		if p.peek() == '#' {
+			p.pos++
+			cmd.text = "#"
+		}
+
+	case 's':
+		cmd.n = int(p.number())
+		if cmd.n == 0 {
+			cmd.n = 1
+		}
+		delim := p.get()
+		if delim == 0 || delim == '\n' || delim == ' ' || isAlnum(delim) {
+			return nil, fmt.Errorf("bad delimiter for s")
+		}
+		cmd.re = p.delimited(delim)
+		cmd.text = p.delimited(delim)
+		if p.peek() == 'g' {
+			p.pos++
+			cmd.global = true
+		}
+
+	case 'm', 't':
+		p.skipSpace()
+		cmd.mtaddr, err = p.compoundAddr()
+		if err != nil {
+			return nil, err
+		}
+		if cmd.mtaddr == nil {
+			return nil, fmt.Errorf("missing address for %c", cmd.c)
+		}
+
+	case 'x', 'y', 'g', 'v', 'X', 'Y':
+		p.skipSpace()
+		delim := p.peek()
+		if delim != 0 && delim != '\n' && !isAlnum(delim) && strings.IndexByte("{|<>!", delim) < 0 {
+			p.pos++
+			cmd.re = p.delimited(delim)
+		} else if cmd.c == 'g' || cmd.c == 'v' {
+			return nil, fmt.Errorf("missing regexp for %c", cmd.c)
+		} else {
+			cmd.lines = true
+		}
+		p.skipSpace()
+		if c := p.peek(); c == 0 || c == '\n' || c == '}' {
+			def := byte('p')
+			if cmd.c == 'X' || cmd.c == 'Y' {
+				def = 'f'
+			}
+			cmd.cmd = &samCmd{c: def}
+		} else {
+			cmd.cmd, err = p.samCmd()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return cmd, nil
+
+	case '{':
+		for {
+			p.skipWhitespace()
+			if p.peek() == '}' {
+				p.pos++
+				break
+			}
+			if p.pos == len(p.s) {
+				return nil, fmt.Errorf("missing }")
+			}
+			c, err := p.samCmd()
+			if err != nil {
+				return nil, err
+			}
+			cmd.cmds = append(cmd.cmds, c)
+		}
+
+	case '|', '<', '>', '!':
+		e := strings.IndexByte(p.s[p.pos:], '\n')
+		if e < 0 {
+			e = len(p.s) - p.pos
+		}
+		cmd.text = strings.TrimSpace(p.s[p.pos : p.pos+e])
+		p.pos += e
+		if cmd.text == "" {
+			return nil, fmt.Errorf("missing shell command for %c", cmd.c)
+		}
+
+	default:
+		return nil, fmt.Errorf("unknown command %q", cmd.c)
+	}
+
+	p.skipSpace()
+	switch p.peek() {
+	case 0, '}':
+	case '\n':
+		p.pos++
+	default:
+		return nil, fmt.Errorf("unexpected text after %c: %q", cmd.c, p.s[p.pos:])
+	}
+	return cmd, nil
+}
+
+// samText parses the text for a, c and i, either delimited as in /text/, or on the following lines up to a line with a single dot.
+func (p *parser) samText() string {
+	p.skipSpace()
+	if c := p.peek(); c == '\n' || c == 0 {
+		p.get()
+		var lines []string
+		for p.pos < len(p.s) {
+			e := strings.IndexByte(p.s[p.pos:], '\n')
+			if e < 0 {
+				e = len(p.s) - p.pos
+			}
+			line := p.s[p.pos : p.pos+e]
+			p.pos += e
+			if p.pos < len(p.s) {
+				p.pos++
+			}
+			if line == "." {
+				break
+			}
+			lines = append(lines, line+"\n")
+		}
+		return strings.Join(lines, "")
+	}
+	delim := p.get()
+	s := p.delimited(delim)
+	return strings.NewReplacer(`\n`, "\n", `\\`, `\`).Replace(s)
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// ui runs fn in the main loop.
+func (x *samExec) ui(fn func()) {
+	if x.async {
+		uiCall(fn)
+	} else {
+		fn()
+	}
+}
+
+func (x *samExec) file(w *fileUI) (*samFile, error) {
+	for _, f := range x.files {
+		if f.win == w {
+			return f, nil
+		}
+	}
+	var f *samFile
+	var err error
+	x.ui(func() {
+		var text []byte
+		text, err = w.body.Text()
+		if err == nil {
+			c0, c1 := w.body.Cursor().Ordered()
+			f = &samFile{win: w, path: w.path(), orig: text, text: text, dot: span{c0, c1}}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	x.files = append(x.files, f)
+	return f, nil
+}
+
+// change records a replacement of s with buf, to be applied when the command finishes.
+func (f *samFile) change(s span, buf []byte) {
+	if s.q0 == s.q1 && len(buf) == 0 {
+		return
+	}
+	f.changes = append(f.changes, samChange{s, buf})
+}
+
+// apply applies the changes from the last command to the text.
+// Dot is set to the range of changed text.
+func (f *samFile) apply() error {
+	if len(f.changes) == 0 {
+		return nil
+	}
+	l := f.changes
+	f.changes = nil
+	sort.SliceStable(l, func(i, j int) bool {
+		return l[i].s.q0 < l[j].s.q0
+	})
+	var buf []byte
+	o := int64(0)
+	var delta int64
+	for i, c := range l {
+		if c.s.q0 < o {
+			return fmt.Errorf("changes not in sequence")
+		}
+		buf = append(buf, f.text[o:c.s.q0]...)
+		buf = append(buf, c.buf...)
+		o = c.s.q1
+		if i == 0 {
+			f.dot.q0 = c.s.q0
+		}
+		if i == len(l)-1 {
+			f.dot.q1 = c.s.q0 + delta + int64(len(c.buf))
+		}
+		delta += int64(len(c.buf)) - (c.s.q1 - c.s.q0)
+	}
+	buf = append(buf, f.text[o:]...)
+	f.text = buf
+	return nil
+}
+
+func (x *samExec) run(f *samFile, cmd *samCmd) error {
+	if f == nil && (cmd.c != 'X' && cmd.c != 'Y' || cmd.addr != nil) {
+		return fmt.Errorf("no current window")
+	}
+	if cmd.addr != nil {
+		e := &addrEval{f.text, f.dot, &x.last}
+		r, err := e.address(cmd.addr, f.dot, 0)
+		if err != nil {
+			return err
+		}
+		f.dot = r
+	}
+
+	switch cmd.c {
+	case 0:
+	case 'a':
+		f.change(span{f.dot.q1, f.dot.q1}, []byte(cmd.text))
+	case 'i':
+		f.change(span{f.dot.q0, f.dot.q0}, []byte(cmd.text))
+	case 'c':
+		f.change(f.dot, []byte(cmd.text))
+	case 'd':
+		f.change(f.dot, nil)
+	case 'p':
+		x.out.Write(f.text[f.dot.q0:f.dot.q1])
+	case '=':
+		fmt.Fprintf(&x.out, "%s:%s\n", f.path, addrString(f.text, f.dot, cmd.text == "#"))
+	case 'f':
+		fmt.Fprintf(&x.out, "%s\n", f.path)
+
+	case 's':
+		return x.substitute(f, cmd)
+
+	case 'm', 't':
+		e := &addrEval{f.text, f.dot, &x.last}
+		r, err := e.address(cmd.mtaddr, f.dot, 0)
+		if err != nil {
+			return err
+		}
+		buf := append([]byte{}, f.text[f.dot.q0:f.dot.q1]...)
+		if cmd.c == 'm' {
+			if r.q1 > f.dot.q0 && r.q1 < f.dot.q1 {
+				return fmt.Errorf("move overlaps itself")
+			}
+			f.change(f.dot, nil)
+		}
+		f.change(span{r.q1, r.q1}, buf)
+
+	case 'x', 'y':
+		re, err := x.regexp(cmd)
+		if err != nil {
+			return err
+		}
+		dot := f.dot
+		l := re.FindAllIndex(f.text[dot.q0:dot.q1], -1)
+		x.nest++
+		defer func() {
+			x.nest--
+		}()
+		op := dot.q0
+		for _, m := range l {
+			if cmd.c == 'x' {
+				f.dot = span{dot.q0 + int64(m[0]), dot.q0 + int64(m[1])}
+			} else {
+				f.dot = span{op, dot.q0 + int64(m[0])}
+				op = dot.q0 + int64(m[1])
+			}
+			if err := x.run(f, cmd.cmd); err != nil {
+				return err
+			}
+		}
+		if cmd.c == 'y' {
+			f.dot = span{op, dot.q1}
+			return x.run(f, cmd.cmd)
+		}
+
+	case 'g', 'v':
+		re, err := x.last.compile(cmd.re)
+		if err != nil {
+			return err
+		}
+		if re.Match(f.text[f.dot.q0:f.dot.q1]) == (cmd.c == 'g') {
+			return x.run(f, cmd.cmd)
+		}
+
+	case 'X', 'Y':
+		var re *regexp.Regexp
+		if !cmd.lines {
+			var err error
+			re, err = x.last.compile(cmd.re)
+			if err != nil {
+				return err
+			}
+		}
+		x.nest++
+		defer func() {
+			x.nest--
+		}()
+		var wins []*fileUI
+		var paths []string
+		x.ui(func() {
+			wins = topUI.windows()
+			for _, w := range wins {
+				paths = append(paths, w.path())
+			}
+		})
+		for i, w := range wins {
+			if re != nil && re.MatchString(paths[i]) != (cmd.c == 'X') {
+				continue
+			}
+			wf, err := x.file(w)
+			if err != nil {
+				return err
+			}
+			if err := x.run(wf, cmd.cmd); err != nil {
+				return err
+			}
+		}
+
+	case '{':
+		dot := f.dot
+		for _, c := range cmd.cmds {
+			f.dot = dot
+			if err := x.run(f, c); err != nil {
+				return err
+			}
+		}
+
+	case '|', '<', '>', '!':
+		return x.shell(f, cmd)
+	}
+	return nil
+}
+
+// regexp returns the regular expression for x and y, each line if none was specified.
+func (x *samExec) regexp(cmd *samCmd) (*regexp.Regexp, error) {
+	if cmd.lines {
+		return regexp.Compile(`(?m).*\n|.+$`)
+	}
+	return x.last.compile(cmd.re)
+}
+
+func (x *samExec) substitute(f *samFile, cmd *samCmd) error {
+	re, err := x.last.compile(cmd.re)
+	if err != nil {
+		return err
+	}
+	dot := f.dot
+	text := f.text[dot.q0:dot.q1]
+	n := 0
+	for _, m := range re.FindAllSubmatchIndex(text, -1) {
+		n++
+		if n < cmd.n {
+			continue
+		}
+		f.change(span{dot.q0 + int64(m[0]), dot.q0 + int64(m[1])}, expandReplacement(cmd.text, text, m))
+		if !cmd.global {
+			break
+		}
+	}
+	if n < cmd.n && x.nest == 0 {
+		return fmt.Errorf("no substitution")
+	}
+	return nil
+}
+
+// expandReplacement returns the replacement text for s, with & for the match, \1 to \9 for subexpressions and \n for newline.
+func expandReplacement(repl string, text []byte, m []int) []byte {
+	var buf []byte
+	for i := 0; i < len(repl); i++ {
+		c := repl[i]
+		switch {
+		case c == '&':
+			buf = append(buf, text[m[0]:m[1]]...)
+		case c == '\\' && i+1 < len(repl):
+			i++
+			c = repl[i]
+			if c >= '1' && c <= '9' {
+				j := int(c - '0')
+				if 2*j+1 < len(m) && m[2*j] >= 0 {
+					buf = append(buf, text[m[2*j]:m[2*j+1]]...)
+				}
+			} else if c == 'n' {
+				buf = append(buf, '\n')
+			} else {
+				buf = append(buf, c)
+			}
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return buf
+}
+
+// shell runs the command for |, <, > and ! as a job, outside the main loop.
+func (x *samExec) shell(f *samFile, cmd *samCmd) error {
+	c := exec.Command("sh", "-c", cmd.text)
+	c.Dir = commandDir(f.path)
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	var j *job
+	x.ui(func() {
+		c.Env = commandEnv(f.win)
+		j = newJob(cmd.text, f.win)
+	})
+	if cmd.c == '|' || cmd.c == '>' {
+		c.Stdin = bytes.NewReader(f.text[f.dot.q0:f.dot.q1])
+	}
+	var stdout bytes.Buffer
+	if cmd.c == '|' || cmd.c == '<' {
+		c.Stdout = &stdout
+	} else {
+		c.Stdout = &x.out
+	}
+	c.Stderr = &x.out
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("%s: %s", cmd.text, err)
+	}
+	j.started(c.Process.Pid)
+	err := c.Wait()
+	j.finished()
+	if err != nil {
+		return fmt.Errorf("%s: %s", cmd.text, err)
+	}
+	if cmd.c == '|' || cmd.c == '<' {
+		f.change(f.dot, stdout.Bytes())
+	}
+	return nil
+}
+
+// edit runs the sam commands in s, for the Edit builtin.
+func (ui *mainUI) edit(filename, s string, edit *duit.Edit) {
+	cmds, err := parseSam(s)
+	if ui.error(filename, err, "Edit") {
+		return
+	}
+	x := &samExec{}
+	var cur *samFile
+	if w := ui.bodyWindow(edit); w != nil {
+		cur, err = x.file(w)
+		if ui.error(filename, err, "Edit") {
+			return
+		}
+	}
+	if !samShell(cmds) {
+		ui.editDone(x, filename, cur, x.exec(cur, cmds))
+		return
+	}
+	x.async = true
+	go func() {
+		err := x.exec(cur, cmds)
+		uiCall(func() {
+			ui.editDone(x, filename, cur, err)
+		})
+	}()
+}
+
+// samShell returns whether cmds run shell commands.
+func samShell(cmds []*samCmd) bool {
+	for _, cmd := range cmds {
+		if cmd == nil {
+			continue
+		}
+		if strings.IndexByte("|<>!", cmd.c) >= 0 || samShell([]*samCmd{cmd.cmd}) || samShell(cmd.cmds) {
+			return true
+		}
+	}
+	return false
+}
+
+// exec runs cmds, applying the changes of each command to the texts.
+func (x *samExec) exec(cur *samFile, cmds []*samCmd) error {
+	for _, cmd := range cmds {
+		err := x.run(cur, cmd)
+		for _, f := range x.files {
+			if err != nil {
+				f.changes = nil
+			} else if err = f.apply(); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// editDone replaces the text of the windows changed by the commands of x, and shows their output and err.
+// Windows closed or changed while shell commands ran are left alone.
+func (ui *mainUI) editDone(x *samExec, filename string, cur *samFile, err error) {
+	for _, f := range x.files {
+		w := f.win
+		if x.async && w.deleted {
+			continue
+		}
+		if x.async && !bytes.Equal(f.orig, f.text) {
+			text, terr := w.body.Text()
+			if terr == nil && !bytes.Equal(text, f.orig) {
+				terr = fmt.Errorf("%s changed while running", f.path)
+			}
+			if ui.error(filename, terr, "Edit") {
+				continue
+			}
+		}
+		if !bytes.Equal(f.orig, f.text) {
+			o, del, ins := textDiff(f.orig, f.text)
+			w.replace('F', w.body, span{int64(o), int64(o + len(del))}, ins)
+		}
+		if f == cur || !bytes.Equal(f.orig, f.text) {
+			w.body.SetCursor(duit.Cursor{Cur: f.dot.q1, Start: f.dot.q0})
+			w.body.ScrollCursor(dui)
+			dui.MarkDraw(w)
+		}
+	}
+	if x.out.Len() > 0 {
+		ui.output(errorDest(filename), x.out.Bytes())
+	}
+	ui.error(filename, err, "Edit")
+}
+
+// bodyWindow returns the window with edit as its body, or nil.
+func (ui *mainUI) bodyWindow(edit *duit.Edit) *fileUI {
+	if edit == nil {
+		return nil
+	}
+	for _, w := range ui.windows() {
+		if w.body == edit {
+			return w
+		}
+	}
+	return nil
+}
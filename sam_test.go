@@ -0,0 +1,80 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSam(t *testing.T) {
+	const text = "one two\nthree four\nfive\n"
+	tests := []struct {
+		text string
+		cmds string
+		want string
+		out  string
+		err  bool
+	}{
+		{text, ",d", "", "", false},
+		{text, "2d", "one two\nfive\n", "", false},
+		{text, "1a/x\\n/", "one two\nx\nthree four\nfive\n", "", false},
+		{text, "1i/x\\n/", "x\none two\nthree four\nfive\n", "", false},
+		{text, "/three/c/3/", "one two\n3 four\nfive\n", "", false},
+		{text, ",s/o/0/", "0ne two\nthree four\nfive\n", "", false},
+		{text, ",s/o/0/g", "0ne tw0\nthree f0ur\nfive\n", "", false},
+		{text, ",s2/o/0/", "one tw0\nthree four\nfive\n", "", false},
+		{text, ",s/(t)(w)/\\2\\1/", "one wto\nthree four\nfive\n", "", false},
+		{text, ",s/two/[&]/", "one [two]\nthree four\nfive\n", "", false},
+		{text, ",s/six/6/", text, "", true},
+		{text, ",x/[a-z]+/c/w/", "w w\nw w\nw\n", "", false},
+		{text, ",y/\\n/d", "\n\n\n", "", false},
+		{text, ",x g/o/d", "five\n", "", false},
+		{text, ",x v/o/d", "one two\nthree four\n", "", false},
+		{text, "1m$", "three four\nfive\none two\n", "", false},
+		{text, "3t0", "five\none two\nthree four\nfive\n", "", false},
+		{text, "2{\ni/</\na/>/\n}", "one two\n<three four\n>five\n", "", false},
+		{text, "/four/=", text, "file.txt:2\n", false},
+		{text, "/four/=#", text, "file.txt:#14,#18\n", false},
+		{text, "2p", text, "three four\n", false},
+		{text, ",x/e+/p", text, "eeee", false},
+		{text, "$-1,$p", text, "five\n", false},
+		{text, "5d", text, "", true},
+		{text, "/six/d", text, "", true},
+		{text, "2,1d", text, "", true},
+		{text, "1x/o/c/0/\n/f/d", "0ne tw0\nthree our\nfive\n", "", false},
+
+		// an empty regexp is the last one of the same Edit
+		{text, ",s/t/T/\n,s//_/g", "one Two\n_hree four\nfive\n", "", false},
+		{text, "/four/\n//d", "one two\nthree \nfive\n", "", false},
+		// and not one of an earlier Edit
+		{text, ",s//x/", text, "", true},
+	}
+	for _, tc := range tests {
+		cmds, err := parseSam(tc.cmds)
+		if err != nil {
+			t.Fatalf("parse %q: %s", tc.cmds, err)
+		}
+		f := &samFile{path: "file.txt", orig: []byte(tc.text), text: []byte(tc.text)}
+		x := &samExec{files: []*samFile{f}}
+		err = x.exec(f, cmds)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: err %v, expected error %v", tc.cmds, err, tc.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if string(f.text) != tc.want {
+			t.Errorf("%q: text %q, expected %q", tc.cmds, f.text, tc.want)
+		}
+		if x.out.String() != tc.out {
+			t.Errorf("%q: output %q, expected %q", tc.cmds, x.out.String(), tc.out)
+		}
+	}
+}
+
+func TestParseSamErrors(t *testing.T) {
+	for _, s := range []string{"z", "s", "sa/x/y/", "g", "x/a/ {", "m", "|", "2,,3p", "1$p", ",dx"} {
+		if _, err := parseSam(s); err == nil {
+			t.Errorf("%q: parsed without error", s)
+		}
+	}
+}
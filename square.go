@@ -10,8 +10,10 @@ import (
 
 type square struct {
 	dirty                               bool
+	busy                                bool // commands started from the window are running
 	cleanColor, borderColor, dirtyColor *draw.Image
 	b1, b2, b3                          func()
+	drop                                func(p image.Point) // if set, called with the absolute position when button 1 is released outside the square
 	lowdpiSize, size                    image.Point
 	m                                   draw.Mouse
 }
@@ -36,6 +38,9 @@ func (ui *square) Draw(dui *duit.DUI, self *duit.Kid, img *draw.Image, orig imag
 	}
 	img.Draw(self.R.Add(orig), bg, nil, image.ZP)
 	img.Border(self.R.Add(orig), dui.Scale(1), ui.borderColor, image.ZP)
+	if ui.busy {
+		img.Draw(self.R.Add(orig).Inset(dui.Scale(4)), ui.borderColor, nil, image.ZP)
+	}
 }
 
 func (ui *square) Mouse(dui *duit.DUI, self *duit.Kid, m draw.Mouse, origM draw.Mouse, orig image.Point) (r duit.Result) {
@@ -45,6 +50,11 @@ func (ui *square) Mouse(dui *duit.DUI, self *duit.Kid, m draw.Mouse, origM draw.
 	if om.Buttons != 0 && m.Buttons == 0 {
 		switch om.Buttons {
 		case duit.Button1:
+			if ui.drop != nil && !m.Point.In(image.Rectangle{Max: ui.size}) {
+				ui.drop(m.Point.Add(orig))
+				r.Consumed = true
+				return
+			}
 			ui.b1()
 		case duit.Button2:
 			ui.b2()
@@ -83,5 +93,5 @@ func (ui *square) Mark(self *duit.Kid, o duit.UI, forLayout bool) (marked bool)
 }
 
 func (ui *square) Print(self *duit.Kid, indent int) {
-	duit.PrintUI(fmt.Sprintf("square dirty=%v", ui.dirty), self, indent)
+	duit.PrintUI(fmt.Sprintf("square dirty=%v busy=%v", ui.dirty, ui.busy), self, indent)
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"9fans.net/go/draw"
+)
+
+var errViBusy = errors.New("body is in vi visual mode or has an unfinished command")
+
+// viState follows the mode of the body, which duit.Edit does not export.
+// It sees the keys the body handles, from its Keys function, and the mouse events that reach its text.
+// Commands are parsed like duit.Edit does, to know when they are complete and which mode they leave the edit in.
+type viState struct {
+	mode    viMode
+	pending string // keys of an unfinished command in command or visual mode
+	buttons int    // of the last mouse event in the text
+}
+
+type viMode int
+
+const (
+	viInsert viMode = iota
+	viCommand
+	viVisual
+)
+
+type viStatus int
+
+const (
+	viPending viStatus = iota
+	viDone
+	viBad
+)
+
+// insert returns whether keys are inserted as text.
+func (v *viState) insert() bool {
+	return v.mode == viInsert
+}
+
+// command returns whether the edit is in command mode without a pending command.
+func (v *viState) command() bool {
+	return v.mode == viCommand && v.pending == ""
+}
+
+// key updates the state for k, handled by the edit. empty is whether the selection is empty before k.
+// It returns the command that k completed in command or visual mode.
+func (v *viState) key(k rune, empty bool) (cmd string) {
+	switch v.mode {
+	case viInsert:
+		if k == draw.KeyEscape {
+			if empty {
+				v.mode = viCommand
+			} else {
+				v.mode = viVisual
+			}
+		}
+		return ""
+	case viCommand:
+		s := v.pending + string(k)
+		status, mode := viCommandStatus(s)
+		if status == viPending {
+			v.pending = s
+			return ""
+		}
+		v.pending = ""
+		if status == viDone {
+			v.mode = mode
+		}
+		return s
+	default:
+		s := v.pending + string(k)
+		status, mode := viVisualStatus(s)
+		if status == viPending {
+			v.pending = s
+			return ""
+		}
+		v.pending = ""
+		v.mode = mode
+		return s
+	}
+}
+
+// mouse updates the state for a mouse event in the text. A change of buttons puts the edit in insert mode.
+func (v *viState) mouse(buttons int) {
+	if buttons != v.buttons {
+		v.mode = viInsert
+		v.pending = ""
+	}
+	v.buttons = buttons
+}
+
+// viNumber parses an optional count at i, returning the offset after it and its value, 1 if absent.
+func viNumber(s string, i int) (int, int, bool) {
+	j := i
+	for j < len(s) && (s[j] >= '1' && s[j] <= '9' || j > i && s[j] == '0') {
+		j++
+	}
+	if j == i {
+		return i, 1, true
+	}
+	n, err := strconv.ParseInt(s[i:j], 10, 32)
+	return j, int(n), err == nil
+}
+
+// viCommandStatus parses command s and returns the mode it leaves the edit in when done.
+func viCommandStatus(s string) (viStatus, viMode) {
+	i, n, ok := viNumber(s, 0)
+	if !ok {
+		return viBad, viCommand
+	}
+	if i == len(s) {
+		return viPending, viCommand
+	}
+	r, size := utf8.DecodeRuneInString(s[i:])
+	switch r {
+	case 'i', 'I', 'a', 'A', 'o', 'O', 's', 'S', 'C':
+		return viDone, viInsert
+	case 'v', 'V':
+		return viDone, viVisual
+	case 'D', 'x', 'X', 'p', 'P', 'J', '~', 'u', 'Y', '*', 'n', 'N', '.', control & 'e', control & 'r', control & 'g':
+		return viDone, viCommand
+	case 'd', 'c', 'y', '<', '>':
+		j, n, ok := viNumber(s, i+size)
+		if !ok {
+			return viBad, viCommand
+		}
+		status := viMotion(s, j, n, r)
+		if status == viDone && r == 'c' {
+			return viDone, viInsert
+		}
+		return status, viCommand
+	}
+	return viMotion(s, i, n, -1), viCommand
+}
+
+// viMotion parses a motion at i in s, with count n. endLine is the key that selects whole lines, e.g. d for dd.
+func viMotion(s string, i, n int, endLine rune) viStatus {
+	if i == len(s) {
+		return viPending
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	switch r {
+	case '$', '%':
+		if n != 1 {
+			return viBad
+		}
+		return viDone
+	case '0', 'w', 'W', 'b', 'B', 'e', 'E', 'h', 'l', 'k', 'j', 'G':
+		return viDone
+	}
+	if r == endLine {
+		return viDone
+	}
+	return viBad
+}
+
+// viVisualStatus parses keys s typed in visual mode and returns the mode they leave the edit in.
+func viVisualStatus(s string) (viStatus, viMode) {
+	switch s[len(s)-1] {
+	case 'i', 's':
+		return viDone, viInsert
+	case 'd', 'y', 'p', '<', '>', 'J', '~', 'o':
+		return viDone, viVisual
+	}
+	i, n, ok := viNumber(s, 0)
+	if !ok {
+		return viBad, viVisual
+	}
+	return viMotion(s, i, n, -1), viVisual
+}
+
+// viKeyChange returns how key k, typed in mode and completing cmd in command or visual mode, may change the text.
+// repeat is set for the vi repeat command, which can make two changes.
+func viKeyChange(mode viMode, k rune, cmd string) (modify, undo, repeat bool) {
+	switch mode {
+	case viInsert:
+		switch k {
+		case draw.KeyUp, draw.KeyDown, draw.KeyLeft, draw.KeyRight, draw.KeyPageUp, draw.KeyPageDown, draw.KeyEscape, control & 'a', control & 'e':
+			return false, false, false
+		case draw.KeyCmd + 'z':
+			return true, true, false
+		}
+		if k >= draw.KeyCmd && k < draw.KeyCmd+128 {
+			// only cut, paste, undo, redo and indent change text
+			switch k - draw.KeyCmd {
+			case 'x', 'v', 'Z', '[', ']':
+				return true, false, false
+			}
+			return false, false, false
+		}
+		return true, false, false
+	case viCommand:
+		if cmd == "" {
+			return false, false, false
+		}
+		i, _, _ := viNumber(cmd, 0)
+		r, _ := utf8.DecodeRuneInString(cmd[i:])
+		switch r {
+		case 'o', 'O', 's', 'S', 'D', 'd', 'C', 'c', 'x', 'X', 'p', 'P', 'J', '~', '<', '>', control & 'r':
+			return true, false, false
+		case 'u':
+			return true, true, false
+		case '.':
+			return true, false, true
+		}
+	default:
+		if cmd != "" && strings.IndexByte("dsp<>J~", cmd[len(cmd)-1]) >= 0 {
+			return true, false, false
+		}
+	}
+	return false, false, false
+}
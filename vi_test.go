@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"9fans.net/go/draw"
+)
+
+func TestViState(t *testing.T) {
+	tests := []struct {
+		keys    string
+		mode    viMode
+		pending string
+	}{
+		// \x1b is draw.KeyEscape
+		{"", viInsert, ""},
+		{"abc", viInsert, ""},
+		{"\x1b", viCommand, ""},
+		{"\x1bd", viCommand, "d"},
+		{"\x1b2d3", viCommand, "2d3"},
+		{"\x1bdd", viCommand, ""},
+		{"\x1bdw", viCommand, ""},
+		{"\x1bdq", viCommand, ""},
+		{"\x1b2$", viCommand, ""},
+		{"\x1bcw", viInsert, ""},
+		{"\x1bcc", viInsert, ""},
+		{"\x1b3o", viInsert, ""},
+		{"\x1bA", viInsert, ""},
+		{"\x1bx", viCommand, ""},
+		{"\x1b10j", viCommand, ""},
+		{"\x1bv", viVisual, ""},
+		{"\x1bvjd", viVisual, ""},
+		{"\x1bv2", viVisual, "2"},
+		{"\x1bvs", viInsert, ""},
+		{"\x1bVi", viInsert, ""},
+	}
+	for _, tc := range tests {
+		var v viState
+		for _, k := range tc.keys {
+			v.key(k, true)
+		}
+		if v.mode != tc.mode || v.pending != tc.pending {
+			t.Errorf("keys %q: mode %d, pending %q, expected %d, %q", tc.keys, v.mode, v.pending, tc.mode, tc.pending)
+		}
+	}
+
+	var v viState
+	v.key(draw.KeyEscape, false)
+	if v.mode != viVisual {
+		t.Errorf("escape with selection: mode %d, expected visual", v.mode)
+	}
+	v.key('2', true)
+	v.mouse(1)
+	if !v.insert() || v.pending != "" {
+		t.Errorf("after click: mode %d, pending %q, expected insert", v.mode, v.pending)
+	}
+	v.key(draw.KeyEscape, true)
+	v.mouse(1)
+	if !v.command() {
+		t.Errorf("mouse without button change: mode %d, expected command", v.mode)
+	}
+}
+
+func TestViKeyChange(t *testing.T) {
+	tests := []struct {
+		mode                 viMode
+		k                    rune
+		cmd                  string
+		modify, undo, repeat bool
+	}{
+		{viInsert, 'a', "", true, false, false},
+		{viInsert, draw.KeyLeft, "", false, false, false},
+		{viInsert, draw.KeyCmd + 'y', "", false, false, false},
+		{viInsert, draw.KeyCmd + 'v', "", true, false, false},
+		{viInsert, draw.KeyCmd + 'z', "", true, true, false},
+		{viCommand, 'd', "", false, false, false},
+		{viCommand, 'w', "3dw", true, false, false},
+		{viCommand, 'j', "10j", false, false, false},
+		{viCommand, 'u', "u", true, true, false},
+		{viCommand, '.', "2.", true, false, true},
+		{viVisual, 'd', "d", true, false, false},
+		{viVisual, 'y', "y", false, false, false},
+	}
+	for _, tc := range tests {
+		modify, undo, repeat := viKeyChange(tc.mode, tc.k, tc.cmd)
+		if modify != tc.modify || undo != tc.undo || repeat != tc.repeat {
+			t.Errorf("mode %d, key %q, cmd %q: got %v %v %v, expected %v %v %v", tc.mode, tc.k, tc.cmd, modify, undo, repeat, tc.modify, tc.undo, tc.repeat)
+		}
+	}
+}
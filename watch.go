@@ -0,0 +1,92 @@
+package main
+
+import (
+	"os"
+	"path"
+	"time"
+
+	"github.com/mjl-/duit"
+)
+
+// fileChanged returns whether the file was replaced or modified between stats a and b.
+func fileChanged(a, b os.FileInfo) bool {
+	return !os.SameFile(a, b) || a.Size() != b.Size() || !a.ModTime().Equal(b.ModTime())
+}
+
+// fileWake wakes watchFiles to check the files of the windows.
+var fileWake = make(chan struct{}, 1)
+
+// wakeWatch makes watchFiles check the files soon, e.g. when a window starts showing a file.
+func wakeWatch() {
+	select {
+	case fileWake <- struct{}{}:
+	default:
+	}
+}
+
+// watchFiles checks the files of clean windows, and reloads windows whose file changed on disk.
+// Modified windows whose file was changed in place are reported, their text may be wrong.
+// The files are checked when inotify reports a change in their directories. Without inotify, they are checked every 2 seconds while windows show files.
+func watchFiles() {
+	type check struct {
+		w    *fileUI
+		p    string
+		stat os.FileInfo
+	}
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	for {
+		select {
+		case <-fileWake:
+		case <-tick:
+		}
+		var l []check
+		var poll bool
+		uiCall(func() {
+			dirs := map[string]bool{}
+			for _, w := range topUI.windows() {
+				if w.stat == nil && w.file == nil {
+					continue
+				}
+				dirs[path.Dir(w.path())] = true
+				if w.stat != nil && !w.square.dirty {
+					l = append(l, check{w, w.path(), w.stat})
+				} else if w.file != nil && !w.staleReported && w.file.check() {
+					w.staleReported = true
+					topUI.error(w.path(), errStale, "check")
+				}
+			}
+			poll = !watchFileDirs(dirs) && len(dirs) > 0
+		})
+		if poll && ticker == nil {
+			ticker = time.NewTicker(2 * time.Second)
+			tick = ticker.C
+		} else if !poll && ticker != nil {
+			ticker.Stop()
+			ticker, tick = nil, nil
+		}
+		for _, c := range l {
+			fi, err := os.Stat(c.p)
+			if err != nil || !fileChanged(c.stat, fi) {
+				continue
+			}
+			c := c
+			dui.Call <- func() {
+				w := c.w
+				if w.deleted || w.square.dirty || w.stat != c.stat || w.path() != c.p {
+					return
+				}
+				c0, c1 := w.body.Cursor().Ordered()
+				w.get()
+				size := editSize(w.body)
+				if c1 <= size {
+					w.body.SetCursor(duit.Cursor{Cur: c1, Start: c0})
+					w.body.ScrollCursor(dui)
+				}
+				for _, z := range w.zeroxFiles() {
+					z.stat = w.stat
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"9fans.net/go/draw"
+	"github.com/mjl-/duit"
+)
+
+// Win opens a window running a shell, or the command given to Win, on a pseudo-terminal.
+// Output is inserted at the output point, complete lines typed after it are sent to the shell.
+// Delete and ^C interrupt, ^D sends the text after the output point without newline and an end of file.
+// The window is named after the directory of the shell followed by /-win, so commands executed from it run there too.
+
+type winProc struct {
+	pty *os.File
+	pid int
+	q   int64 // output point in the body
+	dir string
+
+	// input waiting to be written to the pty, the main loop never waits for the shell to read
+	mu     sync.Mutex
+	cond   *sync.Cond
+	input  [][]byte
+	closed bool
+}
+
+// startWinProc starts args on a new pseudo-terminal, with a goroutine writing queued input to it.
+func startWinProc(dir string, args, env []string) (*winProc, *exec.Cmd, error) {
+	master, slave, err := openPty()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer slave.Close()
+
+	c := exec.Command(args[0], args[1:]...)
+	c.Dir = dir
+	c.Env = env
+	c.Stdin = slave
+	c.Stdout = slave
+	c.Stderr = slave
+	c.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := c.Start(); err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	w := &winProc{pty: master, pid: c.Process.Pid, dir: dir}
+	w.cond = sync.NewCond(&w.mu)
+	go w.writer()
+	return w, c, nil
+}
+
+func (w *winProc) writer() {
+	for {
+		w.mu.Lock()
+		for len(w.input) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		input := w.input
+		w.input = nil
+		closed := w.closed
+		w.mu.Unlock()
+		if closed {
+			return
+		}
+		for _, buf := range input {
+			if _, err := w.pty.Write(buf); err != nil {
+				w.mu.Lock()
+				w.closed = true
+				w.input = nil
+				w.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// startWin starts args, or /bin/sh, in a new window, in the directory of filename.
+func (ui *mainUI) startWin(filename string, args []string) error {
+	dir := commandDir(filename)
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	dir = path.Clean(dir)
+	if len(args) == 0 {
+		args = []string{"/bin/sh"}
+	}
+	f := ui.columns[len(ui.columns)-1].addFile(path.Join(dir, "-win"))
+	w, c, err := startWinProc(dir, args, append(commandEnv(f), "TERM=dumb"))
+	if err != nil {
+		f.del()
+		return err
+	}
+	f.win = w
+	j := newJob(strings.Join(args, " "), f)
+	go func() {
+		j.started(c.Process.Pid)
+		buf := make([]byte, 8*1024)
+		for {
+			n, err := w.pty.Read(buf)
+			if n > 0 {
+				data := append([]byte{}, buf[:n]...)
+				uiCall(func() {
+					if f.win == w {
+						w.output(f, data)
+					}
+				})
+			}
+			if err != nil {
+				break
+			}
+		}
+		err := c.Wait()
+		j.finished()
+		uiCall(func() {
+			if f.win == w {
+				w.close()
+				f.win = nil
+				topUI.error(f.path(), err, "win")
+			}
+		})
+	}()
+	return nil
+}
+
+// replaced updates the output point for a change in the body at s.
+func (w *winProc) replaced(s span, n int) {
+	w.q = shiftOffset(w.q, int(s.q0), int(s.q1-s.q0), n)
+}
+
+// pending returns the text after the output point.
+func (w *winProc) pending(f *fileUI) ([]byte, error) {
+	size := editSize(f.body)
+	if w.q > size {
+		w.q = size
+	}
+	return ioutil.ReadAll(io.NewSectionReader(f.body.Reader(), w.q, size-w.q))
+}
+
+// sendLines sends the complete lines after the output point.
+func (w *winProc) sendLines(f *fileUI) {
+	text, err := w.pending(f)
+	if topUI.error(f.path(), err, "win") {
+		return
+	}
+	if i := bytes.LastIndexByte(text, '\n'); i >= 0 {
+		w.send(text[:i+1])
+		w.q += int64(i) + 1
+	}
+}
+
+// send queues buf for writing to the pty.
+func (w *winProc) send(buf []byte) {
+	w.mu.Lock()
+	if !w.closed {
+		w.input = append(w.input, append([]byte{}, buf...))
+		w.cond.Signal()
+	}
+	w.mu.Unlock()
+}
+
+// output inserts buf at the output point. The cursor moves along if it is at or after the output point.
+func (w *winProc) output(f *fileUI, buf []byte) {
+	c := f.body.Cursor()
+	q := w.q
+	f.replace('F', f.body, span{q, q}, buf)
+	n := int64(len(buf))
+	if c.Cur >= q {
+		c.Cur += n
+	}
+	if c.Start >= q {
+		c.Start += n
+	}
+	f.body.SetCursor(c)
+	f.body.ScrollCursor(dui)
+	w.q = q + n
+
+	if dir, err := processDir(w.pid); err == nil && dir != w.dir {
+		w.dir = dir
+		f.setName(path.Join(dir, "-win"))
+	}
+}
+
+// key handles keys typed in the body that are meant for the terminal, returning whether k was consumed.
+func (w *winProc) key(f *fileUI, k rune) bool {
+	switch k {
+	case draw.KeyDelete, control & 'c':
+		w.send([]byte{control & 'c'})
+	case control & 'd':
+		text, err := w.pending(f)
+		if topUI.error(f.path(), err, "win") {
+			break
+		}
+		w.send(text)
+		w.q += int64(len(text))
+		w.send([]byte{control & 'd'})
+	default:
+		return false
+	}
+	return true
+}
+
+// sendText adds buf to the end of the body, with a newline, and sends it.
+func (w *winProc) sendText(f *fileUI, buf []byte) {
+	if len(buf) == 0 {
+		return
+	}
+	if buf[len(buf)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	size := editSize(f.body)
+	f.replace('F', f.body, span{size, size}, buf)
+	end := size + int64(len(buf))
+	f.body.SetCursor(duit.Cursor{Cur: end, Start: end})
+	f.body.ScrollCursor(dui)
+	w.sendLines(f)
+}
+
+// close stops the shell and its processes.
+func (w *winProc) close() {
+	syscall.Kill(-w.pid, syscall.SIGHUP)
+	w.pty.Close()
+	w.mu.Lock()
+	w.closed = true
+	w.input = nil
+	w.cond.Signal()
+	w.mu.Unlock()
+}
+
+// winSend sends the selection, or the snarf buffer if nothing is selected, to the shell of a win window.
+func (ui *fileUI) winSend() error {
+	if ui.win == nil {
+		return fmt.Errorf("not a win window")
+	}
+	buf, err := ui.body.Selection()
+	if err != nil {
+		return err
+	}
+	if len(buf) == 0 {
+		buf, _ = dui.ReadSnarf()
+	}
+	ui.win.sendText(ui, buf)
+	return nil
+}
+
+// setName replaces the file name in the tag.
+func (ui *fileUI) setName(name string) {
+	t := ui.tagText()
+	i := strings.Index(t, " ")
+	if i < 0 {
+		i = len(t)
+	}
+	ui.replace('F', ui.header, span{0, int64(i)}, []byte(name))
+}
@@ -0,0 +1,88 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWinProc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acvi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(dir+"/sub", 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	w, c, err := startWinProc(dir, []string{"/bin/sh"}, []string{"PS1=", "TERM=dumb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		w.close()
+		c.Wait()
+	}()
+	if d, err := processDir(w.pid); err != nil || d != dir {
+		t.Fatalf("process dir %q, %v, expected %q", d, err, dir)
+	}
+
+	out := make(chan string)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := w.pty.Read(buf)
+			if n > 0 {
+				out <- string(buf[:n])
+			}
+			if err != nil {
+				close(out)
+				return
+			}
+		}
+	}()
+	// wait reads output until it contains s, returning all output read.
+	wait := func(s string) string {
+		var b bytes.Buffer
+		timeout := time.After(5 * time.Second)
+		for !strings.Contains(b.String(), s) {
+			select {
+			case o, ok := <-out:
+				if !ok {
+					t.Fatalf("eof, output %q", b.String())
+				}
+				b.WriteString(o)
+			case <-timeout:
+				t.Fatalf("timeout waiting for %q, output %q", s, b.String())
+			}
+		}
+		return b.String()
+	}
+
+	// many sends must not block, the writer queues them
+	for i := 0; i < 200; i++ {
+		w.send([]byte("true\n"))
+	}
+	w.send([]byte("echo 'he''llo'\n"))
+	if o := wait("hello\n"); strings.Contains(o, "he''llo") || strings.Contains(o, "true") {
+		t.Fatalf("input was echoed: %q", o)
+	}
+
+	w.send([]byte("cd sub && echo done\n"))
+	wait("done\n")
+	if d, err := processDir(w.pid); err != nil || d != dir+"/sub" {
+		t.Fatalf("process dir after cd %q, %v, expected %q", d, err, dir+"/sub")
+	}
+}
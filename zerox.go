@@ -0,0 +1,97 @@
+package main
+
+import (
+	"github.com/mjl-/duit"
+)
+
+// Zerox windows show the same file. Each window has its own duit.Edit and undo history.
+// A change in one window is copied to the others right away, as a replace of the changed text.
+type zerox struct {
+	files []*fileUI
+}
+
+// zerox opens a new window in the same column showing the same text.
+func (ui *fileUI) zerox() {
+	text, err := ui.body.Text()
+	if topUI.error(ui.path(), err, "zerox") {
+		return
+	}
+	if ui.clones == nil {
+		if !ui.tracking() {
+			ui.syncHist()
+		}
+		ui.clones = &zerox{files: []*fileUI{ui}}
+	}
+	f := newFileUI(ui.column, "")
+	f.font = ui.font
+	f.indent = ui.indent
+	f.initShared(ui)
+	if base, err := f.body.Text(); err == nil {
+		o, del, ins := textDiff(base, text)
+		f.replace('F', f.body, span{int64(o), int64(o + len(del))}, ins)
+	}
+	setTag(f.header, ui.path()+" Del | ")
+	f.body.SetCursor(ui.body.Cursor())
+	f.square.dirty = ui.square.dirty
+	f.clones = ui.clones
+	f.clones.files = append(f.clones.files, f)
+	ui.column.files.add(f)
+}
+
+// zeroxFiles returns the windows showing the same text as ui, including ui itself.
+func (ui *fileUI) zeroxFiles() []*fileUI {
+	if ui.clones == nil {
+		return []*fileUI{ui}
+	}
+	return ui.clones.files
+}
+
+// removeZerox removes ui from its zerox windows.
+func (ui *fileUI) removeZerox() {
+	z := ui.clones
+	if z == nil {
+		return
+	}
+	ui.clones = nil
+	for i, f := range z.files {
+		if f == ui {
+			z.files = append(z.files[:i], z.files[i+1:]...)
+			break
+		}
+	}
+	if len(z.files) == 1 {
+		z.files[0].clones = nil
+	}
+}
+
+// zeroxCopy makes the change of s to buf in the body of ui in its zerox windows.
+func (ui *fileUI) zeroxCopy(s span, buf []byte) {
+	if ui.clones == nil {
+		return
+	}
+	o, ndel, nins := int(s.q0), int(s.q1-s.q0), len(buf)
+	for _, f := range ui.clones.files {
+		if f == ui {
+			continue
+		}
+		c := f.body.Cursor()
+		f.replaceText('F', f.body, s, buf)
+		f.body.SetCursor(duit.Cursor{Cur: shiftOffset(c.Cur, o, ndel, nins), Start: shiftOffset(c.Start, o, ndel, nins)})
+		if !ui.square.dirty {
+			f.body.Saved()
+			f.resetHist()
+		}
+	}
+}
+
+// shiftOffset returns the new offset for p after replacing ndel bytes at o with nins bytes.
+func shiftOffset(p int64, o, ndel, nins int) int64 {
+	switch {
+	case p <= int64(o):
+		return p
+	case p >= int64(o+ndel):
+		return p + int64(nins-ndel)
+	default:
+		return int64(o + nins)
+	}
+}
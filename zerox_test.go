@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+	"math/rand"
+	"testing"
+
+	"9fans.net/go/draw"
+	"github.com/mjl-/duit"
+)
+
+func TestZeroxKeys(t *testing.T) {
+	font := &draw.Font{Height: 10}
+	dui = &duit.DUI{Display: &draw.Display{DPI: draw.DefaultDPI, DefaultFont: font}}
+	defer func() {
+		dui = nil
+	}()
+
+	a := newFileUI(nil, "")
+	a.initText([]byte("hello world\nsecond line\nthird line here\n"))
+	z := &zerox{files: []*fileUI{a}}
+	a.clones = z
+	b := newFileUI(nil, "")
+	b.initShared(a)
+	b.clones = z
+	z.files = append(z.files, b)
+	dui.Top.UI = &duit.Box{Kids: duit.NewKids(a, b)}
+	for _, f := range z.files {
+		f.body.Font = font
+		kid := f.bodyBox.Kids[0]
+		kid.R = image.Rect(0, 0, 400, 300)
+		f.body.Layout(dui, kid, kid.R.Size(), true)
+		f.syncHist()
+	}
+
+	// typing, vi commands with undo, redo and repeat, in both windows
+	keys := []rune{draw.KeyCmd + 'z', 'a', 'b', '\n', draw.KeyEscape, 'x', 'x', '0', 'd', 'w', 'u', control & 'r', 'j', '.', 'u', '~', '~', 'v', 'l', 'd', 'i', draw.KeyCmd + 'z', draw.KeyCmd + 'Z'}
+	pool := []rune{'a', '\n', draw.KeyEscape, 'x', 'u', 'd', 'w', 'j', 'k', 'l', '.', 'i', 'o', '~', 'J', 'v', 'c', 'D', '2', draw.KeyCmd + 'z', draw.KeyCmd + 'Z', control & 'r', control & 'w'}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		keys = append(keys, pool[r.Intn(len(pool))])
+	}
+	for i, k := range keys {
+		f := a
+		if i%7 == 3 {
+			f = b
+		}
+		f.bodyKey(k)
+		// duit can leave the cursor after the end of the text
+		if c, n := f.body.Cursor(), editSize(f.body); c.Cur > n || c.Start > n {
+			f.body.SetCursor(duit.Cursor{Cur: n, Start: n})
+		}
+		ta, _ := a.body.Text()
+		tb, _ := b.body.Text()
+		if string(ta) != string(tb) {
+			o, del, ins := textDiff(ta, tb)
+			t.Fatalf("key %d %q: texts differ at %d, %q vs %q", i, k, o, del, ins)
+		}
+	}
+}